@@ -3,29 +3,65 @@ package titan
 import (
 	"context"
 	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/gnasnik/titan-sdk-go/internal/tracing"
 	"github.com/gnasnik/titan-sdk-go/merkledag"
-	"github.com/gnasnik/titan-sdk-go/notify"
 	byteRange "github.com/gnasnik/titan-sdk-go/range"
 	"github.com/gnasnik/titan-sdk-go/titan"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-files"
-	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
 	unixfile "github.com/ipfs/go-unixfs/file"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 )
 
+var log = logging.Logger("titan")
+
 type API interface {
 	// GetFile get a file from the Titan network.
 	// The file is downloaded in chunks and assembled locally.
 	GetFile(ctx context.Context, cid string) (int64, io.ReadCloser, error)
+	// GetNode get a file or a directory from the Titan network as a tree of files.Node, HAMT-sharded directories are supported.
+	// The proofs of work are submitted when the returned node is closed. ONLY support `TraversalModeDFS`.
+	GetNode(ctx context.Context, cid string) (files.Node, error)
+	// GetDirectory get a directory from the Titan network, see GetNode.
+	GetDirectory(ctx context.Context, cid string) (files.Directory, error)
+	// WriteTo get a file or a directory from the Titan network and writes it to the local path, which must not exist.
+	// ONLY support `TraversalModeDFS`.
+	WriteTo(ctx context.Context, cid string, path string) error
+	// OpenFile opens a file in the Titan network for random access, the offsets are mapped to the blocks by seeking
+	// in the UnixFS DAG and the blocks are retrieved on demand whatever the traversal mode is, since the range requests
+	// address the bytes of the CAR file rather than the content of the file.
+	OpenFile(ctx context.Context, cid string) (File, error)
+	// OpenCAR opens the CAR file of the cid for random access, the data is retrieved on demand by range requests
+	// whatever the traversal mode is. The proofs of work are submitted when the file is closed.
+	OpenCAR(ctx context.Context, cid string) (File, error)
+	// GetBlock get a raw block from the Titan network, the data is verified against the cid.
+	GetBlock(ctx context.Context, cid string) (blocks.Block, error)
+	// Download get a file from the Titan network and writes it to the local path, the progress is recorded in a journal
+	// next to the file, so an interrupted download continues where it left off when called again. ONLY support `TraversalModeRange`.
+	Download(ctx context.Context, cid string, path string) error
+	// PutFile chunks the local file into a UnixFS DAG, packs it as a CAR file and uploads it to the Titan network,
+	// returns the root cid of the file.
+	PutFile(ctx context.Context, path string) (cid.Cid, error)
+	// PutCAR uploads the local CAR file to the Titan network, returns the root cid of it. The CAR file must have a single root.
+	PutCAR(ctx context.Context, path string) (cid.Cid, error)
+	// PendingReports returns the workload reports which are not acknowledged by the schedulers yet, the failed
+	// submissions are retried in background with exponential backoff, see `config.OutboxOption`.
+	PendingReports() []titan.ReportStatus
+	// FlushReports submits the pending workload reports right now, it returns the first error.
+	FlushReports(ctx context.Context) error
+	// Close submits the pending proofs of work, stops the servers and releases the connections of the client.
+	// The client can not be used anymore once closed.
+	Close() error
 }
 
 type Client struct {
 	config config.Config
 	titan  *titan.Service
-	dag    ipld.DAGService
-	notify *notify.Notification
 }
 
 func New(opts ...config.Option) (*Client, error) {
@@ -40,27 +76,35 @@ func New(opts ...config.Option) (*Client, error) {
 		return nil, err
 	}
 
-	c := &Client{
+	// the NAT type is discovered in background, the downloads do not wait for it
+	return &Client{
 		config: options,
 		titan:  s,
-		notify: notify.NewNotification(),
-	}
-
-	if options.Mode == config.TraversalModeDFS {
-		c.dag = merkledag.NewDAGService(c.titan)
-	}
+	}, nil
+}
 
-	_, err = s.Discover()
-	if err != nil {
-		return nil, err
-	}
+func (c *Client) PendingReports() []titan.ReportStatus {
+	return c.titan.PendingReports()
+}
 
-	go c.notify.ListenEndOfFile(context.Background(), c.titan.EndOfFile)
+func (c *Client) FlushReports(ctx context.Context) error {
+	return c.titan.FlushReports(ctx)
+}
 
-	return c, nil
+func (c *Client) Close() error {
+	return c.titan.Close()
 }
 
 func (c *Client) GetFile(ctx context.Context, id string) (int64, io.ReadCloser, error) {
+	// the span ends when the file is located, the requests of the data are traced as its children
+	ctx, span := tracing.Start(ctx, "Client.GetFile", attribute.String("titan.cid", id))
+	size, reader, err := c.getFile(ctx, id)
+	span.SetAttributes(attribute.Int64("titan.size", size))
+	tracing.End(span, err)
+	return size, reader, err
+}
+
+func (c *Client) getFile(ctx context.Context, id string) (int64, io.ReadCloser, error) {
 	switch c.config.Mode {
 	case config.TraversalModeDFS:
 		return c.getFileByDFS(ctx, id)
@@ -72,34 +116,176 @@ func (c *Client) GetFile(ctx context.Context, id string) (int64, io.ReadCloser,
 }
 
 func (c *Client) getFileByDFS(ctx context.Context, id string) (int64, io.ReadCloser, error) {
-	cid, err := cid.Decode(id)
+	session, node, _, err := c.getNodeByDFS(ctx, id)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	merkleNode, err := c.dag.Get(ctx, cid)
-	if err != nil {
+	switch node.(type) {
+	case files.File:
+		size, err := node.Size()
+		if err != nil {
+			session.CloseWithError(err)
+			return 0, nil, err
+		}
+		return size, newFileReader(node, endOfFile(session)), nil
+	case files.Directory:
+		err := errors.Errorf("the merkle dag is directory")
+		session.CloseWithError(err)
+		return 0, nil, err
+	default:
+		err := errors.Errorf("operation not supported")
+		session.CloseWithError(err)
 		return 0, nil, err
 	}
+}
 
-	node, err := unixfile.NewUnixfsFile(ctx, c.dag, merkleNode)
+func (c *Client) GetNode(ctx context.Context, id string) (files.Node, error) {
+	if c.config.Mode != config.TraversalModeDFS {
+		return nil, errors.Errorf("unsupported traversal mode")
+	}
+
+	session, node, _, err := c.getNodeByDFS(ctx, id)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
-	size, err := node.Size()
+	return newSessionNode(node, endOfFile(session)), nil
+}
+
+func (c *Client) GetDirectory(ctx context.Context, id string) (files.Directory, error) {
+	node, err := c.GetNode(ctx, id)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
-	switch node.(type) {
-	case files.File:
-		return size, newFileReader(node, c.notify.SendEndOfFileEvent), nil
-	case files.Directory:
-		return 0, nil, errors.Errorf("the merkle dag is directory")
+	dir, ok := node.(files.Directory)
+	if !ok {
+		node.Close()
+		return nil, errors.Errorf("the merkle dag is not directory")
+	}
+
+	return dir, nil
+}
+
+func (c *Client) WriteTo(ctx context.Context, id string, path string) error {
+	node, err := c.GetNode(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	defer node.Close()
+
+	return files.WriteTo(node, path)
+}
+
+func (c *Client) OpenFile(ctx context.Context, id string) (File, error) {
+	switch c.config.Mode {
+	case config.TraversalModeDFS, config.TraversalModeRange:
+		return c.openFileByDFS(ctx, id)
 	default:
-		return 0, nil, errors.Errorf("operation not supported")
+		return nil, errors.Errorf("unsupported traversal mode")
+	}
+}
+
+func (c *Client) openFileByDFS(ctx context.Context, id string) (File, error) {
+	session, node, seek, err := c.getNodeByDFS(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, ok := node.(files.File)
+	if !ok {
+		node.Close()
+		err := errors.Errorf("the merkle dag is not file")
+		session.CloseWithError(err)
+		return nil, err
+	}
+
+	size, err := file.Size()
+	if err != nil {
+		session.CloseWithError(err)
+		return nil, err
+	}
+
+	return newDagFile(file, size, seek, endOfFile(session)), nil
+}
+
+func (c *Client) OpenCAR(ctx context.Context, id string) (File, error) {
+	cid, err := cid.Decode(id)
+	if err != nil {
+		return nil, err
 	}
+
+	r := byteRange.New(c.titan.NewSession(cid, event.ObserverFromContext(ctx)),
+		c.config.RangeSize,
+		c.config.Concurrency,
+		c.config.Verify,
+	)
+
+	return r.OpenCAR(ctx, cid)
+}
+
+func (c *Client) GetBlock(ctx context.Context, id string) (blocks.Block, error) {
+	cid, err := cid.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session := c.titan.NewSession(cid, event.ObserverFromContext(ctx))
+
+	block, err := session.GetBlock(ctx, cid)
+	if e := session.CloseWithError(err); e != nil {
+		log.Errorf("close session failed: %v", e)
+	}
+
+	return block, err
+}
+
+func (c *Client) Download(ctx context.Context, id string, path string) error {
+	if c.config.Mode != config.TraversalModeRange {
+		return errors.Errorf("unsupported traversal mode")
+	}
+
+	cid, err := cid.Decode(id)
+	if err != nil {
+		return err
+	}
+
+	r := byteRange.New(c.titan.NewSession(cid, event.ObserverFromContext(ctx)),
+		c.config.RangeSize,
+		c.config.Concurrency,
+		c.config.Verify,
+	)
+
+	return r.Download(ctx, cid, path)
+}
+
+// getNodeByDFS creates a download session for the cid and returns the UnixFS node of it, the data of the files
+// in the tree are retrieved lazily when they are read. The seek function tells the prefetcher the reader of the file
+// moved to another offset.
+func (c *Client) getNodeByDFS(ctx context.Context, id string) (*titan.Session, files.Node, func(int64), error) {
+	cid, err := cid.Decode(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	session := c.titan.NewSession(cid, event.ObserverFromContext(ctx))
+	dag := merkledag.NewDAGService(session, c.config.PrefetchWindow, c.config.PrefetchWorkers)
+
+	merkleNode, err := dag.Get(ctx, cid)
+	if err != nil {
+		session.CloseWithError(err)
+		return nil, nil, nil, err
+	}
+
+	node, err := unixfile.NewUnixfsFile(ctx, dag, merkleNode)
+	if err != nil {
+		session.CloseWithError(err)
+		return nil, nil, nil, err
+	}
+
+	return session, node, dag.SeekTo, nil
 }
 
 func (c *Client) getFileByRange(ctx context.Context, id string) (int64, io.ReadCloser, error) {
@@ -108,12 +294,26 @@ func (c *Client) getFileByRange(ctx context.Context, id string) (int64, io.ReadC
 		return 0, nil, err
 	}
 
-	r := byteRange.New(c.titan,
+	r := byteRange.New(c.titan.NewSession(cid, event.ObserverFromContext(ctx)),
 		c.config.RangeSize,
 		c.config.Concurrency,
+		c.config.Verify,
 	)
 
+	if c.config.Decode {
+		return r.DecodeFile(ctx, cid)
+	}
+
 	return r.GetFile(ctx, cid)
 }
 
+// endOfFile returns a callback submitting the proofs of work and closing the session once the file is completely read.
+func endOfFile(session *titan.Session) func() {
+	return func() {
+		if err := session.Close(); err != nil {
+			log.Errorf("handle endOfFile event failed: %v", err)
+		}
+	}
+}
+
 var _ API = (*Client)(nil)
@@ -0,0 +1,514 @@
+package titan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/cache"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"github.com/ipfs/go-cid"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testChunkSize = 4 << 10
+
+func newTestNetwork(t *testing.T, opts ...titantest.Option) *titantest.Network {
+	t.Helper()
+
+	network, err := titantest.NewNetwork(opts...)
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	t.Cleanup(func() { network.Close() })
+
+	return network
+}
+
+func newTestClient(t *testing.T, network *titantest.Network, opts ...config.Option) *Client {
+	t.Helper()
+
+	client, err := New(network.ClientOptions(opts...)...)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func addRandomFile(t *testing.T, network *titantest.Network, size int) (cid.Cid, []byte) {
+	t.Helper()
+
+	root, data, err := network.AddRandomFile(size, testChunkSize)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+
+	return root, data
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestGetFile(t *testing.T) {
+	network := newTestNetwork(t)
+	root, data := addRandomFile(t, network, 300<<10)
+
+	car, ok := network.CAR(root)
+	if !ok {
+		t.Fatalf("car of %s not found", root)
+	}
+
+	tests := []struct {
+		name string
+		opts []config.Option
+		want []byte
+	}{
+		{"dfs", []config.Option{config.TraversalModeOption(config.TraversalModeDFS)}, data},
+		{"range", []config.Option{config.TraversalModeOption(config.TraversalModeRange), config.RangeSizeOption(64 << 10)}, car},
+		{"range verified", []config.Option{config.TraversalModeOption(config.TraversalModeRange), config.RangeSizeOption(64 << 10), config.VerifyOption(true)}, car},
+		{"range decoded", []config.Option{config.TraversalModeOption(config.TraversalModeRange), config.RangeSizeOption(64 << 10), config.DecodeOption(true)}, data},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, network, tt.opts...)
+
+			size, reader, err := client.GetFile(testContext(t), root.String())
+			if err != nil {
+				t.Fatalf("get file: %v", err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("read file: %v", err)
+			}
+
+			if size != int64(len(tt.want)) {
+				t.Errorf("size = %d, want %d", size, len(tt.want))
+			}
+
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes not matching the %d bytes wanted", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	network := newTestNetwork(t)
+	root, data := addRandomFile(t, network, 300<<10)
+
+	reads := []struct {
+		name   string
+		offset int64
+		length int
+	}{
+		{"head", 0, 1000},
+		{"inside a chunk", 1000, 1000},
+		{"across chunks", testChunkSize - 10, 100},
+		{"far", 200 << 10, testChunkSize * 3},
+		{"backwards", 10, 10},
+		{"tail", int64(len(data)) - 100, 100},
+	}
+
+	modes := []struct {
+		name string
+		mode config.TraversalMode
+	}{
+		{"dfs", config.TraversalModeDFS},
+		{"range", config.TraversalModeRange},
+	}
+
+	for _, m := range modes {
+		t.Run(m.name, func(t *testing.T) {
+			client := newTestClient(t, network, config.TraversalModeOption(m.mode))
+
+			file, err := client.OpenFile(testContext(t), root.String())
+			if err != nil {
+				t.Fatalf("open file: %v", err)
+			}
+			defer file.Close()
+
+			if file.Size() != int64(len(data)) {
+				t.Fatalf("size = %d, want %d", file.Size(), len(data))
+			}
+
+			for _, r := range reads {
+				want := data[r.offset : r.offset+int64(r.length)]
+
+				buf := make([]byte, r.length)
+				if _, err := file.ReadAt(buf, r.offset); err != nil && err != io.EOF {
+					t.Fatalf("%s: read at %d: %v", r.name, r.offset, err)
+				}
+				if !bytes.Equal(buf, want) {
+					t.Errorf("%s: read at %d does not match the file", r.name, r.offset)
+				}
+
+				if _, err := file.Seek(r.offset, io.SeekStart); err != nil {
+					t.Fatalf("%s: seek to %d: %v", r.name, r.offset, err)
+				}
+				if _, err := io.ReadFull(file, buf); err != nil {
+					t.Fatalf("%s: read after seek to %d: %v", r.name, r.offset, err)
+				}
+				if !bytes.Equal(buf, want) {
+					t.Errorf("%s: read after seek to %d does not match the file", r.name, r.offset)
+				}
+			}
+
+			if n, err := file.ReadAt(make([]byte, 1), int64(len(data))); n != 0 || err != io.EOF {
+				t.Errorf("read at the end = %d, %v, want 0, EOF", n, err)
+			}
+		})
+	}
+}
+
+func TestOpenCAR(t *testing.T) {
+	network := newTestNetwork(t)
+	root, _ := addRandomFile(t, network, 300<<10)
+
+	car, ok := network.CAR(root)
+	if !ok {
+		t.Fatalf("car of %s not found", root)
+	}
+
+	tests := []struct {
+		name      string
+		rangeSize int64
+		bufSize   int
+	}{
+		{"buffer smaller than range", 64 << 10, 32 << 10},
+		{"buffer larger than range", 16 << 10, 50 << 10},
+		{"unaligned buffer", 10 << 10, 3001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, network, config.RangeSizeOption(tt.rangeSize))
+
+			file, err := client.OpenCAR(testContext(t), root.String())
+			if err != nil {
+				t.Fatalf("open car: %v", err)
+			}
+			defer file.Close()
+
+			if file.Size() != int64(len(car)) {
+				t.Fatalf("size = %d, want %d", file.Size(), len(car))
+			}
+
+			got, err := io.ReadAll(readerOnly{file})
+			if err != nil {
+				t.Fatalf("read car: %v", err)
+			}
+			if !bytes.Equal(got, car) {
+				t.Fatalf("sequential read does not match the car file")
+			}
+
+			offset := int64(len(car)) / 3
+			buf := make([]byte, tt.bufSize)
+			if _, err := file.ReadAt(buf, offset); err != nil && err != io.EOF {
+				t.Fatalf("read at %d: %v", offset, err)
+			}
+			if !bytes.Equal(buf, car[offset:offset+int64(tt.bufSize)]) {
+				t.Errorf("read at %d does not match the car file", offset)
+			}
+		})
+	}
+}
+
+// readerOnly hides the other methods of the reader, so io.ReadAll reads by the buffer size it picks.
+type readerOnly struct {
+	io.Reader
+}
+
+func TestCloseFlushesReports(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		// open leaves the file open after reading a part of it, so the proofs are submitted by Close
+		open bool
+	}{
+		{"submitted at end of file", 0, false},
+		{"retried by close", 1, false},
+		{"file left open", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := newTestNetwork(t)
+			root, _ := addRandomFile(t, network, 64<<10)
+			network.Scheduler().FailReports(tt.failures)
+
+			client := newTestClient(t, network)
+
+			if tt.open {
+				file, err := client.OpenFile(testContext(t), root.String())
+				if err != nil {
+					t.Fatalf("open file: %v", err)
+				}
+				if _, err = file.ReadAt(make([]byte, 1000), 0); err != nil {
+					t.Fatalf("read file: %v", err)
+				}
+			} else {
+				_, reader, err := client.GetFile(testContext(t), root.String())
+				if err != nil {
+					t.Fatalf("get file: %v", err)
+				}
+				if _, err = io.Copy(io.Discard, reader); err != nil {
+					t.Fatalf("read file: %v", err)
+				}
+				reader.Close()
+			}
+
+			if pending := len(client.PendingReports()); pending != tt.failures {
+				t.Errorf("pending reports = %d, want %d", pending, tt.failures)
+			}
+
+			if err := client.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			if len(network.Scheduler().Reports()) == 0 {
+				t.Errorf("no workload report submitted")
+			}
+			if pending := len(client.PendingReports()); pending != 0 {
+				t.Errorf("pending reports after close = %d, want 0", pending)
+			}
+		})
+	}
+}
+
+func TestFlushReports(t *testing.T) {
+	network := newTestNetwork(t)
+	root, _ := addRandomFile(t, network, 64<<10)
+	network.Scheduler().FailReports(2)
+
+	client := newTestClient(t, network)
+
+	_, reader, err := client.GetFile(testContext(t), root.String())
+	if err != nil {
+		t.Fatalf("get file: %v", err)
+	}
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	reader.Close()
+
+	steps := []struct {
+		wantErr       bool
+		wantPending   int
+		wantSubmitted bool
+	}{
+		{true, 1, false},
+		{false, 0, true},
+		{false, 0, true},
+	}
+
+	for i, step := range steps {
+		err := client.FlushReports(testContext(t))
+		if (err != nil) != step.wantErr {
+			t.Errorf("flush %d: err = %v, want error %v", i, err, step.wantErr)
+		}
+
+		pending := client.PendingReports()
+		if len(pending) != step.wantPending {
+			t.Errorf("flush %d: pending reports = %d, want %d", i, len(pending), step.wantPending)
+		}
+		if len(pending) > 0 && pending[0].Attempts != 2 {
+			t.Errorf("flush %d: attempts = %d, want 2", i, pending[0].Attempts)
+		}
+
+		if submitted := len(network.Scheduler().Reports()) > 0; submitted != step.wantSubmitted {
+			t.Errorf("flush %d: submitted = %v, want %v", i, submitted, step.wantSubmitted)
+		}
+	}
+}
+
+func TestCacheRanges(t *testing.T) {
+	const rangeSize = 64 << 10
+
+	tests := []struct {
+		name   string
+		edges  titantest.EdgeOptions
+		verify bool
+		// wantCached is whether the second read is served by the cache only
+		wantCached bool
+		wantErr    bool
+	}{
+		{"verified", titantest.EdgeOptions{}, true, true, false},
+		{"not verified", titantest.EdgeOptions{}, false, false, false},
+		{"forged", titantest.EdgeOptions{Corrupt: true}, true, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := newTestNetwork(t, titantest.WithEdges(tt.edges))
+			root, _ := addRandomFile(t, network, 300<<10)
+			car, _ := network.CAR(root)
+
+			c := cache.NewMemoryCache(8 << 20)
+			client := newTestClient(t, network,
+				config.TraversalModeOption(config.TraversalModeRange),
+				config.RangeSizeOption(rangeSize),
+				config.VerifyOption(tt.verify),
+				config.CacheOption(c),
+			)
+
+			read := func() ([]byte, error) {
+				_, reader, err := client.GetFile(testContext(t), root.String())
+				if err != nil {
+					return nil, err
+				}
+				defer reader.Close()
+				return io.ReadAll(reader)
+			}
+
+			got, err := read()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("read err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, _, ok := c.GetRange(testContext(t), root, 0, rangeSize); ok {
+					t.Errorf("range of forged bytes is cached")
+				}
+				return
+			}
+			if !bytes.Equal(got, car) {
+				t.Fatalf("read does not match the car file")
+			}
+
+			requests, _ := network.Edges()[0].Served()
+
+			if got, err = read(); err != nil {
+				t.Fatalf("read again: %v", err)
+			}
+			if !bytes.Equal(got, car) {
+				t.Errorf("read again does not match the car file")
+			}
+
+			again, _ := network.Edges()[0].Served()
+			if cached := again == requests; cached != tt.wantCached {
+				t.Errorf("served by the cache = %v, want %v, edge requests %d then %d", cached, tt.wantCached, requests, again)
+			}
+		})
+	}
+}
+
+func TestGetDirectory(t *testing.T) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomData := func(size int) []byte {
+		data := make([]byte, size)
+		rng.Read(data)
+		return data
+	}
+
+	files := map[string][]byte{
+		"index.html":         []byte("<html></html>"),
+		"empty":              {},
+		"data/large.bin":     randomData(50 << 10),
+		"data/nested/a.txt":  []byte("a"),
+		"data/nested/b.txt":  []byte("b"),
+		"data/nested/c/d.md": []byte("# d"),
+	}
+	// enough entries for the shards of a sharded directory to nest
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("many/%02d.bin", i)] = randomData(1 << 10)
+	}
+
+	tests := []struct {
+		name    string
+		sharded bool
+	}{
+		{"basic", false},
+		{"hamt sharded", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := newTestNetwork(t)
+			root, err := network.AddDirectory(files, testChunkSize, tt.sharded)
+			if err != nil {
+				t.Fatalf("add directory: %v", err)
+			}
+
+			client := newTestClient(t, network)
+
+			dir, err := client.GetDirectory(testContext(t), root.String())
+			if err != nil {
+				t.Fatalf("get directory: %v", err)
+			}
+
+			var names []string
+			it := dir.Entries()
+			for it.Next() {
+				names = append(names, it.Name())
+			}
+			if err = it.Err(); err != nil {
+				t.Fatalf("list directory: %v", err)
+			}
+			dir.Close()
+
+			sort.Strings(names)
+			if want := []string{"data", "empty", "index.html", "many"}; fmt.Sprint(names) != fmt.Sprint(want) {
+				t.Errorf("entries = %v, want %v", names, want)
+			}
+
+			if _, _, err = client.GetFile(testContext(t), root.String()); err == nil {
+				t.Errorf("get file of a directory succeeded")
+			}
+
+			path := filepath.Join(t.TempDir(), "dir")
+			if err = client.WriteTo(testContext(t), root.String(), path); err != nil {
+				t.Fatalf("write directory: %v", err)
+			}
+
+			var written int
+			err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+
+				rel, _ := filepath.Rel(path, p)
+				want, ok := files[filepath.ToSlash(rel)]
+				if !ok {
+					t.Errorf("unexpected file %s", rel)
+					return nil
+				}
+
+				got, err := os.ReadFile(p)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("file %s does not match", rel)
+				}
+				written++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("walk directory: %v", err)
+			}
+			if written != len(files) {
+				t.Errorf("written files = %d, want %d", written, len(files))
+			}
+
+			if err = client.FlushReports(testContext(t)); err != nil {
+				t.Fatalf("flush reports: %v", err)
+			}
+			if len(network.Scheduler().Reports()) == 0 {
+				t.Errorf("no workload report submitted for the directory")
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"encoding/base32"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+	"strings"
+	"sync/atomic"
+)
+
+const blockKeyPrefix = "b"
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Blockstore is a size-limited blockstore, blocks are keyed by multihash like the go-ipfs-blockstore does,
+// so a block can be read by both its CIDv0 and CIDv1.
+type Blockstore struct {
+	storage    storage
+	hashOnRead int32
+}
+
+// NewMemoryBlockstore creates an in-memory blockstore holding at most capacity bytes of blocks,
+// the least recently used blocks are evicted first.
+func NewMemoryBlockstore(capacity int64) *Blockstore {
+	return &Blockstore{storage: newMemoryStorage(capacity)}
+}
+
+// NewDiskBlockstore creates a blockstore storing blocks as files in the directory, holding at most capacity bytes of blocks,
+// the least recently used blocks are evicted first. The blocks are hashed on read, since the files can be altered
+// outside the process.
+func NewDiskBlockstore(dir string, capacity int64) (*Blockstore, error) {
+	storage, err := newDiskStorage(dir, capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &Blockstore{storage: storage, hashOnRead: 1}, nil
+}
+
+func blockKey(c cid.Cid) string {
+	return blockKeyPrefix + strings.ToLower(keyEncoding.EncodeToString(c.Hash()))
+}
+
+func (b *Blockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	return b.storage.remove(blockKey(c))
+}
+
+func (b *Blockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return b.storage.has(blockKey(c))
+}
+
+func (b *Blockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	data, ok, err := b.storage.get(blockKey(c))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+
+	if atomic.LoadInt32(&b.hashOnRead) == 1 {
+		hashed, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hashed.Equals(c) {
+			return nil, blockstore.ErrHashMismatch
+		}
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (b *Blockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	data, ok, err := b.storage.get(blockKey(c))
+	if err != nil {
+		return -1, err
+	}
+
+	if !ok {
+		return -1, ipld.ErrNotFound{Cid: c}
+	}
+
+	return len(data), nil
+}
+
+func (b *Blockstore) Put(ctx context.Context, block blocks.Block) error {
+	return b.storage.put(blockKey(block.Cid()), block.RawData())
+}
+
+func (b *Blockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, block := range blks {
+		if err := b.Put(ctx, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllKeysChan returns the keys as CIDv1 raw, since the blocks are keyed by multihash only.
+func (b *Blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	keys, err := b.storage.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, blockKeyPrefix) {
+				continue
+			}
+
+			hash, err := keyEncoding.DecodeString(strings.ToUpper(strings.TrimPrefix(key, blockKeyPrefix)))
+			if err != nil {
+				log.Debugf("decode block key %s: %v", key, err)
+				continue
+			}
+
+			select {
+			case out <- cid.NewCidV1(cid.Raw, multihash.Multihash(hash)):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *Blockstore) HashOnRead(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&b.hashOnRead, value)
+}
+
+var _ blockstore.Blockstore = (*Blockstore)(nil)
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("cache")
+
+const rangeKeyPrefix = "r"
+
+// Cache is a local cache in front of the Titan network, the data served by the cache is not retrieved from edges again.
+// Only verified data is cached, the blocks are checked against their cid and the ranges of a CAR file against the DAG
+// of its root.
+type Cache interface {
+	// Blockstore returns the blockstore caching the blocks retrieved in `TraversalModeDFS`.
+	Blockstore() blockstore.Blockstore
+	// GetRange returns the cached data of the range [start, end] of the file and the size of the file.
+	GetRange(ctx context.Context, c cid.Cid, start, end int64) (int64, []byte, bool)
+	// PutRange caches the data of the range [start, end] of the file and the size of the file.
+	PutRange(ctx context.Context, c cid.Cid, start, end int64, size int64, data []byte) error
+}
+
+// storage is a size-limited key-value storage with eviction.
+type storage interface {
+	get(key string) ([]byte, bool, error)
+	has(key string) (bool, error)
+	put(key string, data []byte) error
+	remove(key string) error
+	keys() ([]string, error)
+}
+
+type cache struct {
+	blocks blockstore.Blockstore
+	ranges storage
+}
+
+// NewMemoryCache creates an in-memory cache, blocks and ranges share the capacity in bytes.
+func NewMemoryCache(capacity int64) Cache {
+	storage := newMemoryStorage(capacity)
+	return &cache{
+		blocks: &Blockstore{storage: storage},
+		ranges: storage,
+	}
+}
+
+// NewDiskCache creates a cache storing the data as files in the directory, blocks and ranges share the capacity in bytes.
+// The blocks are hashed on read like NewDiskBlockstore does.
+func NewDiskCache(dir string, capacity int64) (Cache, error) {
+	storage, err := newDiskStorage(dir, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cache{
+		blocks: &Blockstore{storage: storage, hashOnRead: 1},
+		ranges: storage,
+	}, nil
+}
+
+// NewBlockstoreCache creates a cache on top of any blockstore, e.g. a flatfs or badger backed one,
+// the ranges are cached in memory with at most rangeCapacity bytes.
+func NewBlockstoreCache(bs blockstore.Blockstore, rangeCapacity int64) Cache {
+	return &cache{
+		blocks: bs,
+		ranges: newMemoryStorage(rangeCapacity),
+	}
+}
+
+func (c *cache) Blockstore() blockstore.Blockstore {
+	return c.blocks
+}
+
+// rangeKey hashes the cid and the range, so the key is safe to be used as a file name.
+func rangeKey(c cid.Cid, start, end int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d-%d", c, start, end)))
+	return rangeKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (c *cache) GetRange(ctx context.Context, id cid.Cid, start, end int64) (int64, []byte, bool) {
+	value, ok, err := c.ranges.get(rangeKey(id, start, end))
+	if err != nil {
+		log.Warnf("get range from cache: %v", err)
+		return 0, nil, false
+	}
+
+	if !ok || len(value) < 8 {
+		return 0, nil, false
+	}
+
+	return int64(binary.BigEndian.Uint64(value[:8])), value[8:], true
+}
+
+func (c *cache) PutRange(ctx context.Context, id cid.Cid, start, end int64, size int64, data []byte) error {
+	value := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(value[:8], uint64(size))
+	copy(value[8:], data)
+
+	return c.ranges.put(rangeKey(id, start, end), value)
+}
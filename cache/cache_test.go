@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+	"os"
+	"testing"
+)
+
+// newBlock returns a raw block of the size filled with the seed.
+func newBlock(t *testing.T, seed byte, size int) blocks.Block {
+	t.Helper()
+
+	data := bytes.Repeat([]byte{seed}, size)
+	block, err := blocks.NewBlockWithCid(data, rawCid(t, data))
+	if err != nil {
+		t.Fatalf("new block: %v", err)
+	}
+	return block
+}
+
+func rawCid(t *testing.T, data []byte) cid.Cid {
+	t.Helper()
+
+	hash, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	return cid.NewCidV1(cid.Raw, hash)
+}
+
+func TestBlockstore(t *testing.T) {
+	stores := []struct {
+		name string
+		new  func(t *testing.T, capacity int64) *Blockstore
+	}{
+		{"memory", func(t *testing.T, capacity int64) *Blockstore {
+			return NewMemoryBlockstore(capacity)
+		}},
+		{"disk", func(t *testing.T, capacity int64) *Blockstore {
+			bs, err := NewDiskBlockstore(t.TempDir(), capacity)
+			if err != nil {
+				t.Fatalf("new disk blockstore: %v", err)
+			}
+			return bs
+		}},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			ctx := context.Background()
+			bs := store.new(t, 3000)
+
+			a, b, c := newBlock(t, 'a', 1000), newBlock(t, 'b', 1000), newBlock(t, 'c', 1000)
+			for _, block := range []blocks.Block{a, b, c} {
+				if err := bs.Put(ctx, block); err != nil {
+					t.Fatalf("put: %v", err)
+				}
+			}
+
+			// the blocks are keyed by multihash, so the CIDv0 of a block finds it too
+			v0 := cid.NewCidV0(a.Cid().Hash())
+			got, err := bs.Get(ctx, v0)
+			if err != nil {
+				t.Fatalf("get by cidv0: %v", err)
+			}
+			if !bytes.Equal(got.RawData(), a.RawData()) {
+				t.Errorf("block got by cidv0 does not match")
+			}
+
+			// b is the least recently used once a was read, it is evicted first
+			if err = bs.Put(ctx, newBlock(t, 'd', 1000)); err != nil {
+				t.Fatalf("put: %v", err)
+			}
+			if _, err = bs.Get(ctx, b.Cid()); !ipld.IsNotFound(err) {
+				t.Errorf("get evicted block: err = %v, want not found", err)
+			}
+			for _, block := range []blocks.Block{a, c} {
+				if has, _ := bs.Has(ctx, block.Cid()); !has {
+					t.Errorf("block %s is evicted, want kept", block.Cid())
+				}
+			}
+
+			// a block larger than the capacity is not cached
+			if err = bs.Put(ctx, newBlock(t, 'e', 4000)); err != nil {
+				t.Fatalf("put: %v", err)
+			}
+			if has, _ := bs.Has(ctx, newBlock(t, 'e', 4000).Cid()); has {
+				t.Errorf("block larger than the capacity is cached")
+			}
+
+			if size, err := bs.GetSize(ctx, c.Cid()); err != nil || size != 1000 {
+				t.Errorf("size = %d, %v, want 1000", size, err)
+			}
+		})
+	}
+}
+
+func TestDiskBlockstoreHashOnRead(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bs, err := NewDiskBlockstore(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("new disk blockstore: %v", err)
+	}
+
+	block := newBlock(t, 'a', 1000)
+	if err = bs.Put(ctx, block); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// the file is altered outside the process
+	path := bs.storage.(*diskStorage).path(blockKey(block.Cid()))
+	if err = os.WriteFile(path, bytes.Repeat([]byte{'x'}, 1000), 0644); err != nil {
+		t.Fatalf("alter block file: %v", err)
+	}
+
+	if _, err = bs.Get(ctx, block.Cid()); err != blockstore.ErrHashMismatch {
+		t.Errorf("get altered block: err = %v, want %v", err, blockstore.ErrHashMismatch)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	caches := []struct {
+		name string
+		new  func(t *testing.T, capacity int64) Cache
+	}{
+		{"memory", func(t *testing.T, capacity int64) Cache {
+			return NewMemoryCache(capacity)
+		}},
+		{"disk", func(t *testing.T, capacity int64) Cache {
+			c, err := NewDiskCache(t.TempDir(), capacity)
+			if err != nil {
+				t.Fatalf("new disk cache: %v", err)
+			}
+			return c
+		}},
+		{"blockstore", func(t *testing.T, capacity int64) Cache {
+			return NewBlockstoreCache(NewMemoryBlockstore(capacity), capacity)
+		}},
+	}
+
+	for _, cache := range caches {
+		t.Run(cache.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := cache.new(t, 1<<20)
+			root := rawCid(t, []byte("file"))
+
+			if _, _, ok := c.GetRange(ctx, root, 0, 99); ok {
+				t.Fatalf("range found in an empty cache")
+			}
+
+			for i := int64(0); i < 3; i++ {
+				data := []byte(fmt.Sprintf("range %d", i))
+				if err := c.PutRange(ctx, root, i*100, i*100+99, 1000, data); err != nil {
+					t.Fatalf("put range: %v", err)
+				}
+			}
+
+			size, data, ok := c.GetRange(ctx, root, 100, 199)
+			if !ok {
+				t.Fatalf("range not found")
+			}
+			if size != 1000 || string(data) != "range 1" {
+				t.Errorf("range = %d, %q, want 1000, %q", size, data, "range 1")
+			}
+
+			// the range is keyed by its bounds
+			if _, _, ok = c.GetRange(ctx, root, 100, 198); ok {
+				t.Errorf("range of other bounds found")
+			}
+		})
+	}
+}
+
+func TestDiskCacheReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	c, err := NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("new disk cache: %v", err)
+	}
+
+	block := newBlock(t, 'a', 1000)
+	root := rawCid(t, []byte("file"))
+	if err = c.Blockstore().Put(ctx, block); err != nil {
+		t.Fatalf("put block: %v", err)
+	}
+	if err = c.PutRange(ctx, root, 0, 99, 1000, []byte("range")); err != nil {
+		t.Fatalf("put range: %v", err)
+	}
+
+	// a smaller capacity evicts on load, the block and the range still fit
+	c, err = NewDiskCache(dir, 2000)
+	if err != nil {
+		t.Fatalf("reopen disk cache: %v", err)
+	}
+
+	if _, err = c.Blockstore().Get(ctx, block.Cid()); err != nil {
+		t.Errorf("get block after reopen: %v", err)
+	}
+	if _, data, ok := c.GetRange(ctx, root, 0, 99); !ok || string(data) != "range" {
+		t.Errorf("range after reopen = %q, %v, want %q", data, ok, "range")
+	}
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"container/list"
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dataSuffix = ".data"
+	tempSuffix = ".tmp"
+)
+
+// diskStorage stores every entry in its own file, sharded into directories by the last two characters of the key
+// like flatfs does. The least recently used entries are removed once the capacity is exceeded, the access order
+// is kept in the modification time of the files so that it survives restarts.
+type diskStorage struct {
+	dir      string
+	lk       sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type diskEntry struct {
+	key  string
+	size int64
+}
+
+func newDiskStorage(dir string, capacity int64) (*diskStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &diskStorage{
+		dir:      dir,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+
+	if err := d.load(); err != nil {
+		return nil, errors.Errorf("load disk storage %s: %v", dir, err)
+	}
+
+	return d, nil
+}
+
+// load rebuilds the index from the files in the directory, the most recently modified first.
+func (d *diskStorage) load() error {
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+
+	var list []file
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		name := entry.Name()
+		if strings.HasSuffix(name, tempSuffix) {
+			// leftover of an interrupted write
+			return os.Remove(path)
+		}
+
+		if !strings.HasSuffix(name, dataSuffix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		list = append(list, file{
+			key:     strings.TrimSuffix(name, dataSuffix),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].modTime.After(list[j].modTime)
+	})
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	for _, f := range list {
+		d.items[f.key] = d.ll.PushBack(&diskEntry{key: f.key, size: f.size})
+		d.size += f.size
+	}
+
+	return d.evict(0)
+}
+
+func (d *diskStorage) path(key string) string {
+	shard := key
+	if len(key) > 2 {
+		shard = key[len(key)-3 : len(key)-1]
+	}
+	return filepath.Join(d.dir, shard, key+dataSuffix)
+}
+
+func (d *diskStorage) get(key string) ([]byte, bool, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	elem, ok := d.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	data, err := os.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		d.removeElement(elem)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	d.ll.MoveToFront(elem)
+
+	now := time.Now()
+	if err = os.Chtimes(d.path(key), now, now); err != nil {
+		log.Debugf("touch cache file failed: %v", err)
+	}
+
+	return data, true, nil
+}
+
+func (d *diskStorage) has(key string) (bool, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	_, ok := d.items[key]
+	return ok, nil
+}
+
+func (d *diskStorage) put(key string, data []byte) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	size := int64(len(data))
+	if size > d.capacity {
+		return nil
+	}
+
+	if elem, ok := d.items[key]; ok {
+		d.ll.Remove(elem)
+		delete(d.items, key)
+		d.size -= elem.Value.(*diskEntry).size
+	}
+
+	if err := d.evict(size); err != nil {
+		return err
+	}
+
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + tempSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	d.items[key] = d.ll.PushFront(&diskEntry{key: key, size: size})
+	d.size += size
+
+	return nil
+}
+
+func (d *diskStorage) remove(key string) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	elem, ok := d.items[key]
+	if !ok {
+		return nil
+	}
+
+	return d.removeElement(elem)
+}
+
+// evict removes the least recently used entries until there is room for the size, the caller must hold lk.
+func (d *diskStorage) evict(size int64) error {
+	for d.ll.Len() > 0 && d.size+size > d.capacity {
+		if err := d.removeElement(d.ll.Back()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeElement removes the entry and its file, the caller must hold lk.
+func (d *diskStorage) removeElement(elem *list.Element) error {
+	entry := d.ll.Remove(elem).(*diskEntry)
+	delete(d.items, entry.key)
+	d.size -= entry.size
+
+	err := os.Remove(d.path(entry.key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *diskStorage) keys() ([]string, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	out := make([]string, 0, len(d.items))
+	for key := range d.items {
+		out = append(out, key)
+	}
+
+	return out, nil
+}
+
+var _ storage = (*diskStorage)(nil)
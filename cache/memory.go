@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// memoryStorage is an in-memory storage which evicts the least recently used entries once the capacity is exceeded.
+type memoryStorage struct {
+	lk       sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key  string
+	data []byte
+}
+
+func newMemoryStorage(capacity int64) *memoryStorage {
+	return &memoryStorage{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryStorage) get(key string) ([]byte, bool, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	m.ll.MoveToFront(elem)
+	return elem.Value.(*memoryEntry).data, true, nil
+}
+
+func (m *memoryStorage) has(key string) (bool, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	_, ok := m.items[key]
+	return ok, nil
+}
+
+func (m *memoryStorage) put(key string, data []byte) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if int64(len(data)) > m.capacity {
+		return nil
+	}
+
+	if elem, ok := m.items[key]; ok {
+		m.removeElement(elem)
+	}
+
+	for m.size+int64(len(data)) > m.capacity {
+		m.removeElement(m.ll.Back())
+	}
+
+	m.items[key] = m.ll.PushFront(&memoryEntry{key: key, data: data})
+	m.size += int64(len(data))
+
+	return nil
+}
+
+func (m *memoryStorage) remove(key string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		m.removeElement(elem)
+	}
+
+	return nil
+}
+
+// removeElement removes the entry from the storage, the caller must hold lk.
+func (m *memoryStorage) removeElement(elem *list.Element) {
+	entry := m.ll.Remove(elem).(*memoryEntry)
+	delete(m.items, entry.key)
+	m.size -= int64(len(entry.data))
+}
+
+func (m *memoryStorage) keys() ([]string, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	out := make([]string, 0, len(m.items))
+	for key := range m.items {
+		out = append(out, key)
+	}
+
+	return out, nil
+}
+
+var _ storage = (*memoryStorage)(nil)
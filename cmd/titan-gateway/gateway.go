@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/pkg/errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	formatRaw = "raw"
+	formatCAR = "car"
+
+	contentTypeRaw = "application/vnd.ipld.raw"
+	contentTypeCAR = "application/vnd.ipld.car"
+
+	// the content of a cid never changes
+	immutableCacheControl = "public, max-age=29030400, immutable"
+)
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{- range .Entries}}
+<li><a href="{{.Href}}">{{.Name}}</a>{{if .Size}} {{.Size}} bytes{{end}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type listingEntry struct {
+	Name string
+	Href string
+	Size int64
+}
+
+// gateway serves the files of the Titan network like an IPFS path gateway.
+type gateway struct {
+	client titan.API
+}
+
+func newGateway(client titan.API) http.Handler {
+	g := &gateway{client: client}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/ipfs/{cid}", g.serve).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/ipfs/{cid}/{path:.*}", g.serve).Methods(http.MethodGet, http.MethodHead)
+
+	return r
+}
+
+func (g *gateway) serve(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	root, err := cid.Decode(vars["cid"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid cid: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	format, err := responseFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if format != "" && vars["path"] != "" {
+		http.Error(w, fmt.Sprintf("format %s does not support paths", format), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("X-Ipfs-Path", r.URL.Path)
+
+	switch format {
+	case formatRaw:
+		g.serveRaw(w, r, root)
+	case formatCAR:
+		g.serveCAR(w, r, root)
+	default:
+		g.serveUnixFS(w, r, root, vars["path"])
+	}
+}
+
+// serveRaw serves the block of the cid.
+func (g *gateway) serveRaw(w http.ResponseWriter, r *http.Request, root cid.Cid) {
+	etag := fmt.Sprintf(`"%s.raw"`, root)
+	if notModified(w, r, etag) {
+		return
+	}
+
+	block, err := g.client.GetBlock(r.Context(), root.String())
+	if err != nil {
+		gatewayError(w, r, err)
+		return
+	}
+
+	setCacheHeaders(w, etag)
+	w.Header().Set("Content-Type", contentTypeRaw)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.bin"`, root))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(block.RawData()))
+}
+
+// serveCAR serves the CAR file of the cid, the Range requests of the client are retrieved by Service.GetRange.
+func (g *gateway) serveCAR(w http.ResponseWriter, r *http.Request, root cid.Cid) {
+	etag := fmt.Sprintf(`"%s.car"`, root)
+	if notModified(w, r, etag) {
+		return
+	}
+
+	file, err := g.client.OpenCAR(r.Context(), root.String())
+	if err != nil {
+		gatewayError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	setCacheHeaders(w, etag)
+	w.Header().Set("Content-Type", contentTypeCAR+"; version=1")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.car"`, root))
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+// serveUnixFS serves the file at the path of the UnixFS DAG, directories are served by their index.html or listed.
+// The Range requests are not served by Service.GetRange, the edges serve the ranges of the CAR file, whose offsets
+// do not map to the offsets of the file content. Seeking the file walks its DAG instead, the blocks before the
+// range are skipped.
+func (g *gateway) serveUnixFS(w http.ResponseWriter, r *http.Request, root cid.Cid, filePath string) {
+	etag := fmt.Sprintf(`"%s"`, path.Join(root.String(), filePath))
+	if notModified(w, r, etag) {
+		return
+	}
+
+	node, err := g.client.GetNode(r.Context(), root.String())
+	if err != nil {
+		gatewayError(w, r, err)
+		return
+	}
+	defer node.Close()
+
+	name := root.String()
+	for _, segment := range strings.Split(filePath, "/") {
+		if segment == "" {
+			continue
+		}
+
+		dir, ok := node.(files.Directory)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s is not a directory", name), http.StatusNotFound)
+			return
+		}
+
+		if node, err = lookup(r.Context(), dir, segment); err != nil {
+			gatewayError(w, r, err)
+			return
+		}
+
+		if node == nil {
+			http.Error(w, fmt.Sprintf("%s not found in %s", segment, name), http.StatusNotFound)
+			return
+		}
+
+		name = segment
+	}
+
+	if dir, ok := node.(files.Directory); ok {
+		// relative links of the directory only work with the trailing slash
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := &url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+			return
+		}
+
+		index, err := lookup(r.Context(), dir, "index.html")
+		if err != nil {
+			gatewayError(w, r, err)
+			return
+		}
+
+		if index == nil {
+			g.serveListing(w, r, dir, etag)
+			return
+		}
+
+		node, name = index, "index.html"
+	}
+
+	file, ok := node.(files.File)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s is not a file", name), http.StatusNotImplemented)
+		return
+	}
+
+	setCacheHeaders(w, etag)
+	// the content type is guessed by the extension of the name, or sniffed from the content
+	http.ServeContent(w, r, name, time.Time{}, file)
+}
+
+func (g *gateway) serveListing(w http.ResponseWriter, r *http.Request, dir files.Directory, etag string) {
+	var entries []listingEntry
+
+	it := dir.Entries()
+	for it.Next() {
+		entry := listingEntry{Name: it.Name(), Href: url.PathEscape(it.Name())}
+
+		if file, ok := it.Node().(files.File); ok {
+			entry.Size, _ = file.Size()
+		} else if _, ok := it.Node().(files.Directory); ok {
+			entry.Href += "/"
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := it.Err(); err != nil {
+		gatewayError(w, r, err)
+		return
+	}
+
+	setCacheHeaders(w, etag)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	err := listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []listingEntry
+	}{r.URL.Path, entries})
+	if err != nil {
+		log.Errorf("render listing: %v", err)
+	}
+}
+
+// lookup returns the entry of the directory with the name, nil if not found.
+func lookup(ctx context.Context, dir files.Directory, name string) (files.Node, error) {
+	it := dir.Entries()
+	for it.Next() {
+		if it.Name() == name {
+			return it.Node(), nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, it.Err()
+}
+
+// responseFormat returns the format requested by the query or the Accept header, empty for the UnixFS file.
+func responseFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if format != formatRaw && format != formatCAR {
+			return "", errors.Errorf("unsupported format: %s", format)
+		}
+		return format, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, contentTypeRaw):
+		return formatRaw, nil
+	case strings.Contains(accept, contentTypeCAR):
+		return formatCAR, nil
+	default:
+		return "", nil
+	}
+}
+
+// notModified replies 304 if the client has the content of the etag, before anything is retrieved from the network.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == etag || match == "W/"+etag || match == "*" {
+			setCacheHeaders(w, etag)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// setCacheHeaders lets the clients cache the content forever, only the successful responses are cacheable.
+func setCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("Etag", etag)
+	w.Header().Set("Cache-Control", immutableCacheControl)
+}
+
+func gatewayError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		// the client is gone
+		return
+	}
+
+	log.Debugf("retrieve %s failed: %v", r.URL.Path, err)
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gnasnik/titan-sdk-go"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGateway(t *testing.T) {
+	network, err := titantest.NewNetwork()
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	defer network.Close()
+
+	file, data, err := network.AddRandomFile(100<<10, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+	car, _ := network.CAR(file)
+
+	dir, err := network.AddDirectory(map[string][]byte{
+		"index.html":        []byte("<html>index</html>"),
+		"data/a.txt":        []byte("a"),
+		"data/nested/b.txt": []byte("b"),
+	}, 4<<10, false)
+	if err != nil {
+		t.Fatalf("add directory: %v", err)
+	}
+
+	client, err := titan.New(network.ClientOptions(config.TraversalModeOption(config.TraversalModeDFS))...)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	defer client.Close()
+
+	srv := httptest.NewServer(newGateway(client))
+	defer srv.Close()
+
+	// the redirects are checked rather than followed
+	httpClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		// wantBody is the whole body, wantContains a part of it when the body is not known in full
+		wantStatus   int
+		wantBody     []byte
+		wantContains string
+		wantHeader   http.Header
+	}{
+		{"file", "/ipfs/" + file.String(), nil, http.StatusOK, data, "",
+			http.Header{"Etag": {`"` + file.String() + `"`}, "Cache-Control": {immutableCacheControl}}},
+		{"file range", "/ipfs/" + file.String(), http.Header{"Range": {"bytes=5000-9999"}}, http.StatusPartialContent, data[5000:10000], "", nil},
+		{"car", "/ipfs/" + file.String() + "?format=car", nil, http.StatusOK, car, "",
+			http.Header{"Content-Type": {contentTypeCAR + "; version=1"}}},
+		{"car range", "/ipfs/" + file.String() + "?format=car", http.Header{"Range": {"bytes=100-1099"}}, http.StatusPartialContent, car[100:1100], "", nil},
+		{"car by accept", "/ipfs/" + file.String(), http.Header{"Accept": {contentTypeCAR}}, http.StatusOK, car, "", nil},
+		{"raw", "/ipfs/" + file.String() + "?format=raw", nil, http.StatusOK, nil, "",
+			http.Header{"Content-Type": {contentTypeRaw}}},
+		{"not modified", "/ipfs/" + file.String(), http.Header{"If-None-Match": {`"` + file.String() + `"`}}, http.StatusNotModified, nil, "", nil},
+		{"unsupported format", "/ipfs/" + file.String() + "?format=tar", nil, http.StatusBadRequest, nil, "", nil},
+		{"format of a path", "/ipfs/" + dir.String() + "/data?format=car", nil, http.StatusBadRequest, nil, "", nil},
+		{"invalid cid", "/ipfs/invalid", nil, http.StatusBadRequest, nil, "", nil},
+		{"directory index", "/ipfs/" + dir.String() + "/", nil, http.StatusOK, []byte("<html>index</html>"), "", nil},
+		{"directory listing", "/ipfs/" + dir.String() + "/data/", nil, http.StatusOK, nil, `href="a.txt"`, nil},
+		{"directory redirect", "/ipfs/" + dir.String() + "/data?filename=x", nil, http.StatusMovedPermanently, nil, "",
+			http.Header{"Location": {"/ipfs/" + dir.String() + "/data/?filename=x"}}},
+		{"nested file", "/ipfs/" + dir.String() + "/data/nested/b.txt", nil, http.StatusOK, []byte("b"), "", nil},
+		{"not found", "/ipfs/" + dir.String() + "/data/missing.txt", nil, http.StatusNotFound, nil, "", nil},
+		{"path of a file", "/ipfs/" + dir.String() + "/index.html/a", nil, http.StatusNotFound, nil, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				t.Fatalf("get %s: %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantBody != nil && !bytes.Equal(body, tt.wantBody) {
+				t.Errorf("got %d bytes not matching the %d bytes wanted", len(body), len(tt.wantBody))
+			}
+			if tt.wantContains != "" && !bytes.Contains(body, []byte(tt.wantContains)) {
+				t.Errorf("body does not contain %q: %s", tt.wantContains, body)
+			}
+			for key := range tt.wantHeader {
+				if got := resp.Header.Get(key); got != tt.wantHeader.Get(key) {
+					t.Errorf("header %s = %q, want %q", key, got, tt.wantHeader.Get(key))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Command titan-gateway serves the files of the Titan network over HTTP like an IPFS gateway, so browsers and the
+// existing IPFS tooling can fetch from Titan:
+//
+//	titan-gateway -locator https://locator.titannet.io:5000 -listen 127.0.0.1:8080
+//	curl http://127.0.0.1:8080/ipfs/<cid>/path/to/file
+//	curl -H "Range: bytes=0-1023" http://127.0.0.1:8080/ipfs/<cid>?format=car
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"github.com/gnasnik/titan-sdk-go"
+	"github.com/gnasnik/titan-sdk-go/config"
+	logging "github.com/ipfs/go-log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var log = logging.Logger("gateway")
+
+func main() {
+	var (
+		listen    = flag.String("listen", "127.0.0.1:8080", "address the gateway listens on")
+		locator   = flag.String("locator", os.Getenv("LOCATOR_API_INFO"), "address of the Titan locator, default $LOCATOR_API_INFO")
+		token     = flag.String("token", "", "token of the Titan network")
+		udp       = flag.String("udp", ":8863", "address the Titan client listens on for HTTP/3")
+		outbox    = flag.String("outbox", "", "directory persisting the workload reports until submitted")
+		timeout   = flag.Duration("timeout", 30*time.Second, "timeout of the requests to the Titan network")
+		tlsPolicy = flag.String("tls", "verify", "verification of the certificates, verify, pin-edges or insecure")
+		caFile    = flag.String("ca", "", "PEM file of the root CAs verifying the locator and the schedulers, default the system roots")
+		logLevel  = flag.String("log-level", "info", "log level")
+	)
+	flag.Parse()
+
+	if err := logging.SetLogLevel("*", *logLevel); err != nil {
+		log.Fatalf("invalid log level: %v", err)
+	}
+
+	if *locator == "" {
+		log.Fatal("the locator address is required, set -locator or $LOCATOR_API_INFO")
+	}
+
+	policy, err := config.ParseTLSPolicy(*tlsPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		data, err := os.ReadFile(*caFile)
+		if err != nil {
+			log.Fatalf("load root CAs: %v", err)
+		}
+
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(data) {
+			log.Fatalf("no certificate found in %s", *caFile)
+		}
+	}
+
+	client, err := titan.New(
+		config.AddressOption(*locator),
+		config.TokenOption(*token),
+		config.ListenAddressOption(*udp),
+		config.TimeoutOption(*timeout),
+		config.OutboxOption(*outbox),
+		config.TraversalModeOption(config.TraversalModeDFS),
+		config.TLSPolicyOption(policy),
+		config.RootCAsOption(rootCAs),
+	)
+	if err != nil {
+		log.Fatalf("create titan client: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           newGateway(client),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Infof("gateway listening on %s", *listen)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	// the client is closed either way, so the proofs of work of the downloads are submitted
+	var failed bool
+	select {
+	case <-sig:
+		log.Info("shutting down")
+	case err := <-serveErr:
+		log.Errorf("serve: %v", err)
+		failed = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorf("shutdown: %v", err)
+	}
+	cancel()
+
+	if err := client.Close(); err != nil {
+		log.Errorf("close client: %v", err)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
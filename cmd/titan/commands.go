@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/event"
+	service "github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const stdout = "-"
+
+func runGet(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	output := fs.String("o", "", "path to write the file or the directory, - for stdout, default the cid")
+	mode := fs.String("mode", "dfs", "traversal mode, dfs decodes the DAG and supports directories, range downloads the raw file and resumes it")
+	concurrency := fs.Int("concurrency", 10, "number of ranges requested concurrently, for range mode")
+	rangeSize := fs.Int64("range-size", 1<<20, "size of the ranges in bytes, for range mode")
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	id := positional[0]
+	if *output == "" {
+		*output = id
+	}
+
+	opts := append(g.options(), config.RangeConcurrencyOption(*concurrency), config.RangeSizeOption(*rangeSize))
+	switch *mode {
+	case "dfs":
+		opts = append(opts, config.TraversalModeOption(config.TraversalModeDFS))
+	case "range":
+		opts = append(opts, config.TraversalModeOption(config.TraversalModeRange))
+	default:
+		return errors.Errorf("unsupported mode: %s", *mode)
+	}
+
+	client, err := titan.New(opts...)
+	if err != nil {
+		return err
+	}
+	defer closeClient(client)
+
+	stats := newTransferStats()
+	ctx = event.WithObserver(ctx, stats.observe)
+
+	switch {
+	case *output == stdout:
+		_, reader, err := client.GetFile(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(os.Stdout, reader)
+		if e := reader.Close(); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			return err
+		}
+	case *mode == "range":
+		// the progress is journaled, an interrupted download continues when the command runs again
+		if err = client.Download(ctx, id, *output); err != nil {
+			return err
+		}
+	default:
+		if err = client.WriteTo(ctx, id, *output); err != nil {
+			return err
+		}
+	}
+
+	stats.print(os.Stderr)
+	return nil
+}
+
+func runCAR(ctx context.Context, g *globals, args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return errors.Errorf("unknown subcommand, want: car export <cid>")
+	}
+
+	fs := flag.NewFlagSet("car export", flag.ExitOnError)
+	output := fs.String("o", "", "path to write the CAR file, - for stdout, default <cid>.car")
+	concurrency := fs.Int("concurrency", 10, "number of ranges requested concurrently")
+	rangeSize := fs.Int64("range-size", 1<<20, "size of the ranges in bytes")
+
+	positional, err := parseArgs(fs, args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	id := positional[0]
+	if *output == "" {
+		*output = id + ".car"
+	}
+
+	// the range mode without decoding retrieves the CAR file itself, by concurrent range requests
+	opts := append(g.options(),
+		config.TraversalModeOption(config.TraversalModeRange),
+		config.RangeConcurrencyOption(*concurrency),
+		config.RangeSizeOption(*rangeSize),
+	)
+
+	client, err := titan.New(opts...)
+	if err != nil {
+		return err
+	}
+	defer closeClient(client)
+
+	_, reader, err := client.GetFile(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != stdout {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d bytes of %s\n", n, id)
+	return nil
+}
+
+func runStat(ctx context.Context, g *globals, args []string) error {
+	positional, err := parseArgs(flag.NewFlagSet("stat", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	client, err := titan.New(g.options()...)
+	if err != nil {
+		return err
+	}
+	defer closeClient(client)
+
+	// only the root block is retrieved, it holds the type and the size of the whole file
+	block, err := client.GetBlock(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+
+	c := block.Cid()
+	fmt.Printf("CID:        %s\n", c)
+	fmt.Printf("Block size: %d\n", len(block.RawData()))
+
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		fmt.Printf("Codec:      raw\n")
+		fmt.Printf("Type:       raw\n")
+		fmt.Printf("Size:       %d\n", len(block.RawData()))
+	case cid.DagProtobuf:
+		node, err := merkledag.DecodeProtobuf(block.RawData())
+		if err != nil {
+			return errors.Errorf("decode dag-pb node: %v", err)
+		}
+
+		fsNode, err := unixfs.ExtractFSNode(node)
+		if err != nil {
+			return errors.Errorf("decode unixfs node: %v", err)
+		}
+
+		cumulative, err := node.Size()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Codec:      dag-pb\n")
+		fmt.Printf("Type:       %s\n", strings.ToLower(fsNode.Type().String()))
+		if !fsNode.IsDir() {
+			fmt.Printf("Size:       %d\n", fsNode.FileSize())
+		}
+		fmt.Printf("DAG size:   %d\n", cumulative)
+		fmt.Printf("Links:      %d\n", len(node.Links()))
+	default:
+		fmt.Printf("Codec:      0x%x\n", c.Prefix().Codec)
+	}
+
+	return nil
+}
+
+func runEdges(ctx context.Context, g *globals, args []string) error {
+	positional, err := parseArgs(flag.NewFlagSet("edges", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	root, err := cid.Decode(positional[0])
+	if err != nil {
+		return errors.Errorf("invalid cid: %v", err)
+	}
+
+	s, err := newService(g)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// the edges are reached the way a download does, which depends on the NAT type of this host
+	if _, err = s.DiscoverIfDue(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "discover NAT type failed, assume %s: %v\n", s.NATType(), err)
+	}
+
+	edges, err := s.ProbeEdges(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("NAT type: %s\n\n", s.NATType())
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tADDRESS\tNAT TYPE\tSCHEDULER\tREACHABLE")
+	for _, edge := range edges {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", edge.NodeID, edge.Address, edge.NATType, edge.SchedulerURL, reachability(edge))
+	}
+	return tw.Flush()
+}
+
+func reachability(edge service.EdgeStatus) string {
+	if edge.Err != nil {
+		return fmt.Sprintf("no: %v", edge.Err)
+	}
+	return "yes"
+}
+
+func runNAT(ctx context.Context, g *globals, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("nat", flag.ExitOnError), args, 0); err != nil {
+		return err
+	}
+
+	s, err := newService(g)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	report, err := s.DiscoverIfDue(ctx)
+
+	fmt.Printf("NAT type:    %s\n", report.NATType)
+	fmt.Printf("Scheduler:   %s\n", report.Scheduler)
+	fmt.Printf("Candidates:  %v\n", report.Candidates)
+	if report.PublicAddr.IP != "" {
+		fmt.Printf("Public addr: %s\n", report.PublicAddr)
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if areaID, schedulers, e := s.Schedulers(ctx); e == nil {
+		fmt.Fprintf(tw, "SCHEDULER OF %s\tLATENCY\tSTATUS\n", areaID)
+		for _, scheduler := range schedulers {
+			status := "up"
+			if time.Now().Before(scheduler.DownUntil) {
+				status = "down"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", scheduler.URL, scheduler.Latency.Round(time.Microsecond), status)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(report.Tests) == 0 && err == nil {
+		fmt.Fprintf(tw, "the NAT type is cached in %s, discovered again once expired\n", g.natCache)
+		return tw.Flush()
+	}
+
+	fmt.Fprintln(tw, "TEST\tDESCRIPTION\tCANDIDATE\tDURATION\tRESULT")
+	for _, test := range report.Tests {
+		result := "ok"
+		if test.Err != nil {
+			result = fmt.Sprintf("failed: %v", test.Err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", test.Name, test.Description, test.Candidate, test.Duration.Round(time.Millisecond), result)
+	}
+	if e := tw.Flush(); e != nil {
+		return e
+	}
+
+	return err
+}
+
+func runReports(ctx context.Context, g *globals, args []string) error {
+	fs := flag.NewFlagSet("reports", flag.ExitOnError)
+	flush := fs.Bool("flush", false, "submit the pending reports right now")
+
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	if g.outbox == "" {
+		return errors.Errorf("no outbox directory, set -outbox")
+	}
+
+	if !*flush {
+		// the outbox is only read, a client opening it would submit the reports
+		reports, err := service.ReadReports(g.outbox)
+		if err != nil {
+			return err
+		}
+		return printReports(reports)
+	}
+
+	s, err := newService(g)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err = s.FlushReports(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "flush reports failed: %v\n", err)
+	}
+
+	return printReports(s.PendingReports())
+}
+
+func printReports(reports []service.ReportStatus) error {
+	if len(reports) == 0 {
+		fmt.Println("no pending reports")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSCHEDULER\tCREATED\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", r.ID, r.SchedulerURL, r.CreatedAt.Format(time.RFC3339), r.Attempts,
+			r.NextAttempt.Format(time.RFC3339), r.LastError)
+	}
+	return tw.Flush()
+}
+
+// newService creates the service the diagnostic commands run on, the NAT type is discovered in background like for
+// a client, the commands depending on it wait for the discovery and report the failures.
+func newService(g *globals) (*service.Service, error) {
+	options := config.DefaultOption()
+	for _, opt := range g.options() {
+		opt(&options)
+	}
+
+	return service.New(options)
+}
+
+func closeClient(client *titan.Client) {
+	if err := client.Close(); err != nil {
+		log.Warnf("close client: %v", err)
+	}
+}
+
+// transferStats sums the bytes received from each edge during a download.
+type transferStats struct {
+	lk       sync.Mutex
+	received map[string]int64
+	failures map[string]int
+	total    int64
+	duration time.Duration
+}
+
+func newTransferStats() *transferStats {
+	return &transferStats{
+		received: make(map[string]int64),
+		failures: make(map[string]int),
+	}
+}
+
+func (t *transferStats) observe(e event.Event) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	switch e.Type {
+	case event.RangeCompleted, event.BlockReceived:
+		t.received[e.NodeID] += e.Bytes
+	case event.RangeFailed, event.BlockFailed:
+		t.failures[e.NodeID]++
+	case event.Finished:
+		t.total += e.Bytes
+		t.duration += e.Duration
+	}
+}
+
+func (t *transferStats) print(w io.Writer) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	nodes := make([]string, 0, len(t.received))
+	for node := range t.received {
+		nodes = append(nodes, node)
+	}
+	for node := range t.failures {
+		if _, ok := t.received[node]; !ok {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+
+	fmt.Fprintf(w, "received %d bytes from %d edges in %s\n", t.total, len(t.received), t.duration.Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tBYTES\tFAILURES")
+	for _, node := range nodes {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", node, t.received[node], t.failures[node])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/gnasnik/titan-sdk-go"
+	"github.com/gnasnik/titan-sdk-go/config"
+	service "github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestNetwork(t *testing.T, opts ...titantest.Option) *titantest.Network {
+	t.Helper()
+
+	network, err := titantest.NewNetwork(opts...)
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	t.Cleanup(func() { network.Close() })
+
+	return network
+}
+
+// newTestGlobals returns the global flags of the commands run on the network, the state files are in a directory
+// of the test.
+func newTestGlobals(t *testing.T, network *titantest.Network) *globals {
+	dir := t.TempDir()
+
+	return &globals{
+		locator:  network.Address(),
+		udp:      "127.0.0.1:0",
+		outbox:   filepath.Join(dir, "outbox"),
+		natCache: filepath.Join(dir, "nat.json"),
+		timeout:  30 * time.Second,
+		rootCAs:  network.RootCAs(),
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// runCommand runs the command and returns what it printed to stdout.
+func runCommand(t *testing.T, g *globals, name string, args ...string) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	printed := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- data
+	}()
+
+	err = commands[name].run(testContext(t), g, args)
+	w.Close()
+
+	return string(<-printed), err
+}
+
+func TestGet(t *testing.T) {
+	network := newTestNetwork(t)
+	root, data, err := network.AddRandomFile(300<<10, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+	car, _ := network.CAR(root)
+
+	tests := []struct {
+		name string
+		args []string
+		want []byte
+	}{
+		{"dfs", nil, data},
+		{"range", []string{"-mode", "range", "-range-size", "65536"}, car},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGlobals(t, network)
+			path := filepath.Join(t.TempDir(), "file")
+
+			if _, err := runCommand(t, g, "get", append([]string{root.String(), "-o", path}, tt.args...)...); err != nil {
+				t.Fatalf("get: %v", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read file: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes not matching the %d bytes wanted", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestNAT(t *testing.T) {
+	network := newTestNetwork(t)
+	g := newTestGlobals(t, network)
+	primary := network.Candidates()[0]
+
+	out, err := runCommand(t, g, "nat")
+	if err != nil {
+		t.Fatalf("nat: %v", err)
+	}
+	if !strings.Contains(out, "TEST") {
+		t.Errorf("tests of the discovery not printed:\n%s", out)
+	}
+	if observed := primary.Observed(); observed != 1 {
+		t.Errorf("discoveries = %d, want 1", observed)
+	}
+
+	// the NAT type is cached by the first command
+	if out, err = runCommand(t, g, "nat"); err != nil {
+		t.Fatalf("nat again: %v", err)
+	}
+	if !strings.Contains(out, "cached") {
+		t.Errorf("cached NAT type not printed:\n%s", out)
+	}
+	if observed := primary.Observed(); observed != 1 {
+		t.Errorf("discoveries after the cached NAT type = %d, want 1", observed)
+	}
+}
+
+func TestEdges(t *testing.T) {
+	network := newTestNetwork(t, titantest.WithEdges(titantest.EdgeOptions{}))
+	root, _, err := network.AddRandomFile(64<<10, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+
+	out, err := runCommand(t, newTestGlobals(t, network), "edges", root.String())
+	if err != nil {
+		t.Fatalf("edges: %v", err)
+	}
+
+	edge := network.Edges()[0].NodeID
+	if !strings.Contains(out, edge) || !strings.Contains(out, "yes") {
+		t.Errorf("reachable edge %s not printed:\n%s", edge, out)
+	}
+	if observed := network.Candidates()[0].Observed(); observed != 1 {
+		t.Errorf("discoveries = %d, want 1", observed)
+	}
+}
+
+func TestReports(t *testing.T) {
+	network := newTestNetwork(t)
+	root, _, err := network.AddRandomFile(64<<10, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+	g := newTestGlobals(t, network)
+
+	// the submission at the end of the file and the one of close fail, the report is left in the outbox
+	network.Scheduler().FailReports(2)
+
+	client, err := titan.New(network.ClientOptions(config.OutboxOption(g.outbox))...)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	_, reader, err := client.GetFile(testContext(t), root.String())
+	if err != nil {
+		t.Fatalf("get file: %v", err)
+	}
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	reader.Close()
+	client.Close()
+
+	pending, err := service.ReadReports(g.outbox)
+	if err != nil {
+		t.Fatalf("read reports: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("pending reports = %d, want 1", len(pending))
+	}
+
+	out, err := runCommand(t, g, "reports")
+	if err != nil {
+		t.Fatalf("reports: %v", err)
+	}
+	if !strings.Contains(out, pending[0].ID) {
+		t.Errorf("pending report %s not listed:\n%s", pending[0].ID, out)
+	}
+	if submitted := len(network.Scheduler().Reports()); submitted != 0 {
+		t.Errorf("reports submitted by the listing = %d, want 0", submitted)
+	}
+
+	if out, err = runCommand(t, g, "reports", "-flush"); err != nil {
+		t.Fatalf("reports -flush: %v", err)
+	}
+	if !strings.Contains(out, "no pending reports") {
+		t.Errorf("reports left after flush:\n%s", out)
+	}
+	if len(network.Scheduler().Reports()) == 0 {
+		t.Errorf("no workload report submitted by the flush")
+	}
+}
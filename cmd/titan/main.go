@@ -0,0 +1,205 @@
+// Command titan retrieves files from the Titan network and diagnoses the retrieval, so operators can debug it
+// without writing Go:
+//
+//	titan get <cid> -o path            downloads a file or a directory
+//	titan car export <cid> -o file.car exports the CAR file of the cid
+//	titan stat <cid>                   prints the type and the size of the file or the directory of the cid
+//	titan edges <cid>                  lists the edges holding the cid, their NAT types and whether they are reachable
+//	titan nat                          discovers the NAT type of this host and prints the outcome of each test
+//	titan reports                      lists the workload reports not acknowledged by the schedulers yet
+//
+// The global flags are given before the command:
+//
+//	titan -locator https://locator.titannet.io:5000 nat
+//
+// The certificates of the locator and the schedulers are verified against the system roots, or the CAs of -ca,
+// and -tls pin-edges also pins the certificate of each edge to the public key its scheduler advertises.
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/config"
+	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// natTTL is how long the cached NAT type is trusted by the commands
+const natTTL = 30 * time.Minute
+
+var log = logging.Logger("cli")
+
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, g *globals, args []string) error
+}
+
+var commands = map[string]command{
+	"get":     {"get <cid> [-o path] [-mode dfs|range] [-concurrency n] [-range-size bytes]", "download a file or a directory", runGet},
+	"car":     {"car export <cid> [-o file.car] [-concurrency n] [-range-size bytes]", "export the CAR file of the cid", runCAR},
+	"stat":    {"stat <cid>", "print the type and the size of the file or the directory of the cid", runStat},
+	"edges":   {"edges <cid>", "list the edges holding the cid and whether they are reachable", runEdges},
+	"nat":     {"nat", "discover the NAT type of this host", runNAT},
+	"reports": {"reports [-flush]", "list the workload reports not acknowledged by the schedulers yet", runReports},
+}
+
+// globals are the flags shared by all commands.
+type globals struct {
+	locator  string
+	token    string
+	udp      string
+	outbox   string
+	natCache string
+	timeout  time.Duration
+	tls      config.TLSPolicy
+	rootCAs  *x509.CertPool
+}
+
+// options returns the options of the client from the global flags.
+func (g *globals) options() []config.Option {
+	return []config.Option{
+		config.AddressOption(g.locator),
+		config.TokenOption(g.token),
+		config.ListenAddressOption(g.udp),
+		config.TimeoutOption(g.timeout),
+		config.OutboxOption(g.outbox),
+		config.NATDiscoveryOption(natTTL, g.natCache),
+		config.TLSPolicyOption(g.tls),
+		config.RootCAsOption(g.rootCAs),
+	}
+}
+
+func main() {
+	var g globals
+
+	flag.StringVar(&g.locator, "locator", os.Getenv("LOCATOR_API_INFO"), "address of the Titan locator, default $LOCATOR_API_INFO")
+	flag.StringVar(&g.token, "token", "", "token of the Titan network")
+	flag.StringVar(&g.udp, "udp", ":8863", "address the Titan client listens on for HTTP/3")
+	flag.StringVar(&g.outbox, "outbox", stateFile("outbox"), "directory persisting the workload reports until submitted")
+	flag.StringVar(&g.natCache, "nat-cache", stateFile("nat.json"), "file caching the NAT type of this host between the commands")
+	flag.DurationVar(&g.timeout, "timeout", 30*time.Second, "timeout of the requests to the Titan network")
+	tlsPolicy := flag.String("tls", "verify", "verification of the certificates, verify, pin-edges or insecure")
+	caFile := flag.String("ca", "", "PEM file of the root CAs verifying the locator and the schedulers, default the system roots")
+	logLevel := flag.String("log-level", "error", "log level")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := logging.SetLogLevel("*", *logLevel); err != nil {
+		fatalf("invalid log level: %v", err)
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if g.locator == "" {
+		fatalf("the locator address is required, set -locator or $LOCATOR_API_INFO")
+	}
+
+	var err error
+	if g.tls, err = config.ParseTLSPolicy(*tlsPolicy); err != nil {
+		fatalf("%v", err)
+	}
+
+	if *caFile != "" {
+		if g.rootCAs, err = loadRootCAs(*caFile); err != nil {
+			fatalf("load root CAs: %v", err)
+		}
+	}
+
+	// an interrupted download is resumed by running the command again
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := cmd.run(ctx, &g, flag.Args()[1:]); err != nil {
+		fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: titan [flags] <command> [args]\n\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// stateFile returns the path of the file in the home directory, so the state left by a command, like the workload
+// reports not submitted yet, is taken over by the next one.
+func stateFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".titan", name)
+}
+
+// loadRootCAs reads the PEM encoded certificates of the file.
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificate found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// parseArgs parses the flags of the command wherever they are, before or after the positional arguments,
+// and checks the number of the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		if fs.NArg() == 0 {
+			break
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != n {
+		return nil, errors.Errorf("want %d arguments, got %d", n, len(positional))
+	}
+
+	return positional, nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "titan: "+format+"\n", args...)
+	os.Exit(1)
+}
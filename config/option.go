@@ -1,6 +1,12 @@
 package config
 
 import (
+	"crypto/x509"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/cache"
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/gnasnik/titan-sdk-go/selector"
+	"github.com/pkg/errors"
 	"net/http"
 	"time"
 )
@@ -11,26 +17,78 @@ const (
 	// TraversalModeDFS only supports retrieving CAR files and auto decodes them into the raw file format.
 	TraversalModeDFS TraversalMode = iota + 1
 	// TraversalModeRange allows you to retrieve files of any type, but it does not decode, the files will be retrieved in their original format.
-	// It's important to note that when using `TraversalModeRange` to retrieve a CAR file, the entire file must be downloaded before it can be decoded.
+	// It's important to note that when using `TraversalModeRange` to retrieve a CAR file, the entire file must be downloaded before it can be decoded,
+	// unless `DecodeOption` is set, then the CAR file is decoded as the ranges arrive.
 	TraversalModeRange
 )
 
+type TLSPolicy int
+
+const (
+	// TLSPolicyVerify verifies the certificates of the locator and the schedulers against the root CAs, the edges and
+	// the candidates serve self-signed certificates which are not verified.
+	TLSPolicyVerify TLSPolicy = iota
+	// TLSPolicyPinEdges verifies the locator and the schedulers like `TLSPolicyVerify`, and pins the certificate of each
+	// edge to the public key its scheduler advertises for the node id.
+	TLSPolicyPinEdges
+	// TLSPolicyInsecure verifies no certificate, for test networks only.
+	TLSPolicyInsecure
+)
+
+var tlsPolicies = map[TLSPolicy]string{
+	TLSPolicyVerify:   "verify",
+	TLSPolicyPinEdges: "pin-edges",
+	TLSPolicyInsecure: "insecure",
+}
+
+func (p TLSPolicy) String() string {
+	if name, ok := tlsPolicies[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("TLSPolicy(%d)", int(p))
+}
+
+// ParseTLSPolicy returns the policy by its name, one of verify, pin-edges and insecure.
+func ParseTLSPolicy(name string) (TLSPolicy, error) {
+	for policy, n := range tlsPolicies {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return 0, errors.Errorf("unknown TLS policy: %s", name)
+}
+
 const (
 	defaultListenAddr             = ":8863"
 	defaultRangeConcurrency       = 10
 	defaultRangeSize        int64 = 1 << 20 // 1 MiB
+	defaultPrefetchWindow         = 64
+	defaultPrefetchWorkers        = 8
+	defaultNATTTL                 = 30 * time.Minute
 )
 
 // Config is a set of titan SDK options.
 type Config struct {
-	ListenAddr  string
-	Address     string
-	Token       string
-	HttpClient  *http.Client
-	Timeout     time.Duration
-	Mode        TraversalMode
-	Concurrency int   // for range mode
-	RangeSize   int64 // for range mode
+	ListenAddr      string
+	Address         string
+	Token           string
+	HttpClient      *http.Client
+	Timeout         time.Duration
+	Mode            TraversalMode
+	Concurrency     int   // for range mode
+	RangeSize       int64 // for range mode
+	Verify          bool  // for range mode
+	Decode          bool  // for range mode
+	PrefetchWindow  int   // for dfs mode
+	PrefetchWorkers int   // for dfs mode
+	Cache           cache.Cache
+	Strategy        selector.Strategy
+	OutboxDir       string
+	Observer        event.Observer
+	NATTTL          time.Duration
+	NATCachePath    string
+	TLSPolicy       TLSPolicy
+	RootCAs         *x509.CertPool
 }
 
 // Option is a single titan sdk Config.
@@ -39,14 +97,31 @@ type Option func(opts *Config)
 // DefaultOption returns a default set of options.
 func DefaultOption() Config {
 	return Config{
-		Mode:        TraversalModeDFS,
-		ListenAddr:  defaultListenAddr,
-		Concurrency: defaultRangeConcurrency,
-		RangeSize:   defaultRangeSize,
-		Timeout:     30 * time.Second,
+		Mode:            TraversalModeDFS,
+		ListenAddr:      defaultListenAddr,
+		Concurrency:     defaultRangeConcurrency,
+		RangeSize:       defaultRangeSize,
+		PrefetchWindow:  defaultPrefetchWindow,
+		PrefetchWorkers: defaultPrefetchWorkers,
+		Timeout:         30 * time.Second,
+		Strategy:        selector.PowerOfTwoChoices(),
+		NATTTL:          defaultNATTTL,
 	}
 }
 
+// Validate checks the options which can not work, e.g. the ranges must not be empty.
+func (c Config) Validate() error {
+	if c.RangeSize <= 0 {
+		return errors.Errorf("invalid range size: %d, want positive", c.RangeSize)
+	}
+
+	if c.Concurrency <= 0 {
+		return errors.Errorf("invalid range concurrency: %d, want positive", c.Concurrency)
+	}
+
+	return nil
+}
+
 // AddressOption set titan server address
 func AddressOption(address string) Option {
 	return func(opts *Config) {
@@ -82,7 +157,7 @@ func ListenAddressOption(addr string) Option {
 	}
 }
 
-// RangeConcurrencyOption limits the maximum number of concurrency HTTP requests allowed at the same time.
+// RangeConcurrencyOption limits the maximum number of concurrency HTTP requests allowed at the same time, it must be positive.
 //
 // This option only works when using `TraversalModeRange` to download files.
 func RangeConcurrencyOption(concurrency int) Option {
@@ -91,7 +166,8 @@ func RangeConcurrencyOption(concurrency int) Option {
 	}
 }
 
-// RangeSizeOption specifies the maximum size of each file range that can be downloaded in a single HTTP request.
+// RangeSizeOption specifies the maximum size of each file range that can be downloaded in a single HTTP request,
+// it must be positive.
 // Each range of data is read into memory and then written to the output stream, so the amount of memory used is
 // directly proportional to the size of rangeSize.
 //
@@ -111,3 +187,106 @@ func TimeoutOption(timeout time.Duration) Option {
 		opts.Timeout = timeout
 	}
 }
+
+// VerifyOption enables verifying the retrieved data against the DAG of the requested cid, the data must be a CAR file
+// whose blocks are in DFS pre-order. Any block that does not match its cid or is not linked from the root fails the read.
+//
+// This option only works when using `TraversalModeRange` to download files, blocks are always verified in `TraversalModeDFS`.
+// Random reads by `OpenCAR` are not verified, since a part of the CAR file can not be checked against the DAG.
+func VerifyOption(verify bool) Option {
+	return func(opts *Config) {
+		opts.Verify = verify
+	}
+}
+
+// DecodeOption enables decoding the CAR file of the requested cid while it is downloaded, `GetFile` returns the UnixFS
+// file reconstructed from the blocks instead of the CAR file, and every block is verified on the fly. The ranges are
+// decoded in order and only those not yet read are kept in memory, so a large video can be played while downloading.
+//
+// This option only works when using `TraversalModeRange` with `GetFile`, the CAR file must be in DFS pre-order.
+func DecodeOption(decode bool) Option {
+	return func(opts *Config) {
+		opts.Decode = decode
+	}
+}
+
+// PrefetchOption set the number of blocks fetched ahead of the reader and the number of workers fetching them,
+// default 64 blocks by 8 workers. The blocks are fetched in the order they are read, zero disables prefetching.
+//
+// This option only works when using `TraversalModeDFS` to download files.
+func PrefetchOption(window, workers int) Option {
+	return func(opts *Config) {
+		opts.PrefetchWindow = window
+		opts.PrefetchWorkers = workers
+	}
+}
+
+// CacheOption set a local cache in front of the Titan network, the blocks and ranges served by the cache are not retrieved
+// from edges again, default no cache. The ranges are only cached once verified, by the reads with `VerifyOption`.
+// See the cache package for the in-memory and on-disk implementations.
+func CacheOption(c cache.Cache) Option {
+	return func(opts *Config) {
+		opts.Cache = c
+	}
+}
+
+// EdgeSelectStrategyOption set the strategy to pick an edge for each request, default `selector.PowerOfTwoChoices`.
+// Whatever the strategy is, edges failing repeatedly are banned for a while.
+func EdgeSelectStrategyOption(strategy selector.Strategy) Option {
+	return func(opts *Config) {
+		opts.Strategy = strategy
+	}
+}
+
+// OutboxOption set the directory persisting the workload reports until the schedulers acknowledge them, default none,
+// the reports are only held in memory. The failed submissions are retried in background, and the reports left by
+// a previous process are submitted when the client is created with the same directory.
+func OutboxOption(dir string) Option {
+	return func(opts *Config) {
+		opts.OutboxDir = dir
+	}
+}
+
+// ObserverOption add an observer receiving the events of all downloads, default none. The option can be given more
+// than once, the observers are called in order. The events of a single download can be observed by the context passed
+// to it, see `event.WithObserver`.
+func ObserverOption(observer event.Observer) Option {
+	return func(opts *Config) {
+		prev := opts.Observer
+		if prev == nil {
+			opts.Observer = observer
+			return
+		}
+
+		opts.Observer = func(e event.Event) {
+			prev(e)
+			observer(e)
+		}
+	}
+}
+
+// NATDiscoveryOption set how long the discovered NAT type is trusted and the file persisting it, default 30 minutes
+// and not persisted. The NAT type is discovered in background when the client is created, when it expires, when the
+// addresses of the host change or when the NAT traversals keep failing. Meanwhile the downloads assume a port restricted
+// cone NAT. The NAT type persisted by a previous process is used right away if it did not expire and the host is in
+// the same network.
+func NATDiscoveryOption(ttl time.Duration, cachePath string) Option {
+	return func(opts *Config) {
+		opts.NATTTL = ttl
+		opts.NATCachePath = cachePath
+	}
+}
+
+// TLSPolicyOption set how the certificates of the Titan nodes are verified, default `TLSPolicyVerify`.
+func TLSPolicyOption(policy TLSPolicy) Option {
+	return func(opts *Config) {
+		opts.TLSPolicy = policy
+	}
+}
+
+// RootCAsOption set the root CAs verifying the certificates of the locator and the schedulers, default the system roots.
+func RootCAsOption(pool *x509.CertPool) Option {
+	return func(opts *Config) {
+		opts.RootCAs = pool
+	}
+}
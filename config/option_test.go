@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"range size", []Option{RangeSizeOption(1 << 10)}, false},
+		{"empty range", []Option{RangeSizeOption(0)}, true},
+		{"negative range", []Option{RangeSizeOption(-1)}, true},
+		{"no concurrency", []Option{RangeConcurrencyOption(0)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultOption()
+			for _, opt := range tt.opts {
+				opt(&c)
+			}
+
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Package event defines the events emitted by the downloads, so applications can show the progress of a download
+// per edge. Observers are registered for all downloads by `config.ObserverOption`, or for a single download by
+// the context passed to it:
+//
+//	ctx = event.WithObserver(ctx, func(e event.Event) {
+//		if e.Type == event.RangeCompleted {
+//			fmt.Printf("%s served %d bytes in %s\n", e.NodeID, e.Bytes, e.Duration)
+//		}
+//	})
+//	size, reader, err := client.GetFile(ctx, cid)
+package event
+
+import (
+	"context"
+	"github.com/ipfs/go-cid"
+	"time"
+)
+
+// Type is the kind of event.
+type Type string
+
+const (
+	// EdgesDiscovered is emitted when the scheduler returned the edges of the file, Edges is the number of them.
+	EdgesDiscovered Type = "EdgesDiscovered"
+	// EdgeConnected is emitted per edge once the client tried to reach it, NATType is the NAT of the edge,
+	// and Err is set if the edge is not accessible.
+	EdgeConnected Type = "EdgeConnected"
+	// RangeStarted is emitted when a range of [Start, End] is requested from the edge.
+	RangeStarted Type = "RangeStarted"
+	// RangeCompleted is emitted when the edge served the range, Bytes were received in Duration.
+	RangeCompleted Type = "RangeCompleted"
+	// RangeFailed is emitted when the request of the range failed with Err after Duration.
+	RangeFailed Type = "RangeFailed"
+	// RangeRetried is emitted when a failed range is requested again, Attempt is the number of the retry.
+	RangeRetried Type = "RangeRetried"
+	// BlockReceived is emitted when the edge served the block of Cid, Bytes were received in Duration.
+	BlockReceived Type = "BlockReceived"
+	// BlockFailed is emitted when the request of the block of Cid failed with Err after Duration.
+	BlockFailed Type = "BlockFailed"
+	// ProofSubmitted is emitted when the workload reports were submitted to the scheduler, Err is set if the
+	// submission failed, the reports are retried later.
+	ProofSubmitted Type = "ProofSubmitted"
+	// Finished is emitted once when the download ends, Bytes is the total received from the edges in Duration,
+	// Err is set if the download was interrupted.
+	Finished Type = "Finished"
+)
+
+// Event is a step of a download, only the fields documented by the type are set.
+type Event struct {
+	Type Type
+	Time time.Time
+	// Root is the cid of the file being downloaded
+	Root         cid.Cid
+	Cid          cid.Cid
+	NodeID       string
+	NATType      string
+	SchedulerURL string
+	Edges        int
+	Start        int64
+	End          int64
+	Bytes        int64
+	Duration     time.Duration
+	Attempt      int
+	Err          error
+}
+
+// Observer receives the events, it is called synchronously by the download so it must not block.
+type Observer func(e Event)
+
+type observerKey struct{}
+
+// WithObserver returns a context holding the observer, the download started with the context emits the events to it.
+func WithObserver(ctx context.Context, observer Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, observer)
+}
+
+// ObserverFromContext returns the observer held by the context, nil if none.
+func ObserverFromContext(ctx context.Context) Observer {
+	observer, _ := ctx.Value(observerKey{}).(Observer)
+	return observer
+}
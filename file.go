@@ -0,0 +1,117 @@
+package titan
+
+import (
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+// File is a handle of a file in the Titan network that supports random access, only the parts being read are retrieved.
+// The proofs of work are submitted when the file is closed.
+type File interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	// Size returns the size of the file.
+	Size() int64
+}
+
+// dagFile is a random-access handle of a UnixFS file, seeking is done by walking the DAG of the file.
+type dagFile struct {
+	lk     sync.Mutex
+	file   files.File
+	size   int64
+	offset int64 // the offset of Read and Seek
+	pos    int64 // the offset of the underlying DAG reader
+	seek   func(offset int64)
+	once   sync.Once
+	notify func()
+}
+
+// newDagFile creates the handle of the file, seekFunc is called before the DAG reader seeks to another offset.
+func newDagFile(file files.File, size int64, seekFunc func(offset int64), notifyFunc func()) *dagFile {
+	return &dagFile{
+		file:   file,
+		size:   size,
+		seek:   seekFunc,
+		notify: notifyFunc,
+	}
+}
+
+func (f *dagFile) Size() int64 {
+	return f.size
+}
+
+func (f *dagFile) ReadAt(p []byte, off int64) (int, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	return f.readAt(p, off)
+}
+
+// readAt reads from the underlying DAG reader, it only seeks when the offset is not where the last read stopped.
+func (f *dagFile) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("negative offset: %d", off)
+	}
+
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	if off != f.pos {
+		f.seek(off)
+		if _, err := f.file.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.pos = off
+	}
+
+	n, err := io.ReadFull(f.file, p)
+	f.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *dagFile) Read(p []byte) (int, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *dagFile) Seek(offset int64, whence int) (int64, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, errors.Errorf("negative position: %d", offset)
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *dagFile) Close() error {
+	err := f.file.Close()
+	f.once.Do(f.notify)
+	return err
+}
+
+var _ File = (*dagFile)(nil)
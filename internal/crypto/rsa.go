@@ -45,3 +45,26 @@ func Encrypt(msg []byte, pub *rsa.PublicKey) ([]byte, error) {
 
 	return encryptedBytes, nil
 }
+
+func Decrypt(msg []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	msgLen := len(msg)
+	hash := crypto.SHA256.New()
+	step := priv.PublicKey.Size()
+	var decryptedBytes []byte
+
+	for start := 0; start < msgLen; start += step {
+		finish := start + step
+		if finish > msgLen {
+			finish = msgLen
+		}
+
+		decryptedBlockBytes, err := rsa.DecryptOAEP(hash, rand.Reader, priv, msg[start:finish], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		decryptedBytes = append(decryptedBytes, decryptedBlockBytes...)
+	}
+
+	return decryptedBytes, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/gnasnik/titan-sdk-go/internal/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
 	"net/url"
@@ -24,22 +26,34 @@ type request struct {
 	Headers   http.Header
 }
 
-func PostJsonRPC(client *http.Client, url string, in Request, requestHeader http.Header) ([]byte, error) {
+// PostJsonRPC sends the JSON-RPC request and returns the raw result, the request is aborted when the context is done.
+func PostJsonRPC(ctx context.Context, client *http.Client, url string, in Request, requestHeader http.Header) ([]byte, error) {
 	b, err := json.Marshal(&in)
 	if err != nil {
 		return nil, errors.Errorf("marshalling request: %v", err)
 	}
 
+	ctx, span := tracing.Start(ctx, "JsonRPC "+in.Method,
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", in.Method),
+		attribute.String("http.url", url),
+	)
+
 	var out Response
-	err = NewBuilder(client, url, "rpc", requestHeader).BodyBytes(b).Exec(context.Background(), &out)
+	err = NewBuilder(client, url, "rpc", requestHeader).BodyBytes(b).Exec(ctx, &out)
 	if err != nil {
-		return nil, errors.Errorf("send request: %v", err)
+		err = errors.Errorf("send request: %v", err)
+		tracing.End(span, err)
+		return nil, err
 	}
 
 	if out.Error != nil {
+		tracing.End(span, out.Error)
 		return nil, out.Error
 	}
 
+	span.End()
+
 	return json.Marshal(out.Result)
 }
 
@@ -129,13 +143,11 @@ func (e *Error) Error() string {
 
 func (r *request) Send(c *http.Client, method string) (*response, error) {
 	url := r.getURL()
-	req, err := http.NewRequest(method, url, r.Body)
+	req, err := http.NewRequestWithContext(r.Ctx, method, url, r.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	req = req.WithContext(r.Ctx)
-
 	// Add any headers that were supplied via the Builder.
 	req.Header = r.Headers.Clone()
 
@@ -95,10 +95,15 @@ func (r *Builder) Get(ctx context.Context) (*response, error) {
 func (r *Builder) Head(ctx context.Context) (http.Header, error) {
 	req := NewRequest(ctx, r.baseApi, r.namespace, r.headers)
 	req.Opts = r.opts
-	resp, err := r.client.Head(req.getURL())
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodHead, req.getURL(), nil)
 	if err != nil {
 		return nil, err
 	}
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
 	return resp.Header, nil
 }
 
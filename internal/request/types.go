@@ -3,6 +3,7 @@ package request
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
 )
 
 type ErrorCode int
@@ -38,3 +39,9 @@ func (e *respError) Error() string {
 	}
 	return e.Message
 }
+
+// IsRPCError returns true if the error is answered by the JSON-RPC server, so the server is up even if the call failed.
+func IsRPCError(err error) bool {
+	var e *respError
+	return errors.As(err, &e)
+}
@@ -3,7 +3,9 @@ package merkledag
 import (
 	"context"
 	"github.com/gnasnik/titan-sdk-go/titan"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	ipld "github.com/ipfs/go-ipld-format"
 	ipldlegacy "github.com/ipfs/go-ipld-legacy"
 	logging "github.com/ipfs/go-log"
@@ -13,61 +15,165 @@ import (
 var log = logging.Logger("dag-service")
 
 type dagService struct {
-	titan *titan.Service
+	session    *titan.Session
+	prefetcher *prefetcher
+	// staging holds the nodes added locally, nil if the service is read only
+	staging blockstore.Blockstore
 }
 
-// NewDAGService constructs a new NewDAGService (using the default implementation).
-func NewDAGService(service *titan.Service) *dagService {
+// NewDAGService constructs a new NewDAGService (using the default implementation). The blocks linked from the nodes
+// retrieved are prefetched by `workers` goroutines, at most `window` blocks ahead of the reader, zero disables prefetching.
+func NewDAGService(session *titan.Session, window, workers int) *dagService {
+	d := &dagService{
+		session: session,
+	}
+
+	if window > 0 && workers > 0 {
+		d.prefetcher = newPrefetcher(session, window, workers)
+	}
+
+	return d
+}
+
+// NewStagingDAGService constructs a DAG service storing the nodes added in the blockstore, e.g. to build the DAG
+// of a file to upload. The nodes not found in the blockstore are retrieved from titan network, if session is not nil.
+func NewStagingDAGService(session *titan.Session, bs blockstore.Blockstore) *dagService {
 	return &dagService{
-		titan: service,
+		session: session,
+		staging: bs,
 	}
 }
 
-// Get retrieves a node from titan network
+// Get retrieves a node from the staging blockstore or titan network
 func (d *dagService) Get(ctx context.Context, cid cid.Cid) (ipld.Node, error) {
-	block, err := d.titan.GetBlock(ctx, cid)
+	if d.staging != nil {
+		block, err := d.staging.Get(ctx, cid)
+		if err == nil {
+			return decodeNode(ctx, block)
+		}
+
+		if !ipld.IsNotFound(err) {
+			return nil, err
+		}
+
+		if d.session == nil {
+			return nil, err
+		}
+	}
+
+	if d.prefetcher == nil {
+		return d.get(ctx, cid)
+	}
+
+	if pf := d.prefetcher.take(cid); pf != nil {
+		select {
+		case <-pf.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if pf.err == nil {
+			return pf.node, nil
+		}
+		// the prefetch may have failed with the context of another reader, retrieve it again
+	}
+
+	node, err := d.get(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+
+	d.prefetcher.retrieved(ctx, cid, node)
+
+	return node, nil
+}
+
+// SeekTo tells the DAG service the reader of the file moved to the offset, the blocks prefetched for the previous
+// position are evicted.
+func (d *dagService) SeekTo(offset int64) {
+	if d.prefetcher != nil {
+		d.prefetcher.seek(offset)
+	}
+}
+
+func (d *dagService) get(ctx context.Context, cid cid.Cid) (ipld.Node, error) {
+	block, err := d.session.GetBlock(ctx, cid)
 	if err != nil {
 		return nil, errors.Errorf("dagService: get block %v", err)
 	}
 
+	return decodeNode(ctx, block)
+}
+
+func decodeNode(ctx context.Context, block blocks.Block) (ipld.Node, error) {
 	return ipldlegacy.DecodeNode(ctx, block)
 }
 
-// GetMany gets many nodes at once, batching the request if possible.
+// GetMany gets many nodes at once, the nodes are delivered in the order of the cids.
 func (d *dagService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
-	out := make(chan *ipld.NodeOption)
+	out := make(chan *ipld.NodeOption, len(cids))
+
+	if d.prefetcher != nil {
+		d.prefetcher.enqueue(ctx, cids)
+	}
 
-	for _, k := range cids {
-		go func(k cid.Cid) {
+	go func() {
+		defer close(out)
+
+		for _, k := range cids {
 			node, err := d.Get(ctx, k)
-			out <- &ipld.NodeOption{
-				Node: node,
-				Err:  err,
+			select {
+			case out <- &ipld.NodeOption{Node: node, Err: err}:
+			case <-ctx.Done():
+				return
 			}
-		}(k)
-	}
+		}
+	}()
 
 	return out
 }
 
-// Add unimplemented
+// Add adds a node to the staging blockstore.
 func (d *dagService) Add(ctx context.Context, node ipld.Node) error {
-	return errors.New("unimplemented")
+	if d.staging == nil {
+		return errors.New("dagService: read only")
+	}
+
+	return d.staging.Put(ctx, node)
 }
 
-// AddMany unimplemented
+// AddMany adds many nodes to the staging blockstore.
 func (d *dagService) AddMany(ctx context.Context, nodes []ipld.Node) error {
-	return errors.New("unimplemented")
+	if d.staging == nil {
+		return errors.New("dagService: read only")
+	}
+
+	blks := make([]blocks.Block, len(nodes))
+	for i, node := range nodes {
+		blks[i] = node
+	}
+
+	return d.staging.PutMany(ctx, blks)
 }
 
-// Remove unimplemented
+// Remove removes a node from the staging blockstore.
 func (d *dagService) Remove(ctx context.Context, cid cid.Cid) error {
-	return errors.New("unimplemented")
+	if d.staging == nil {
+		return errors.New("dagService: read only")
+	}
+
+	return d.staging.DeleteBlock(ctx, cid)
 }
 
-// RemoveMany unimplemented
+// RemoveMany removes many nodes from the staging blockstore.
 func (d *dagService) RemoveMany(ctx context.Context, cids []cid.Cid) error {
-	return errors.New("unimplemented")
+	for _, c := range cids {
+		if err := d.Remove(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 var _ ipld.DAGService = (*dagService)(nil)
@@ -0,0 +1,267 @@
+package merkledag
+
+import (
+	"container/list"
+	"context"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs"
+	"sync"
+	"time"
+)
+
+// prefetchTTL is how long a prefetched block is held for the reader, the blocks which are not located in the file
+// and never consumed are evicted once expired so they do not keep the window full.
+const prefetchTTL = 30 * time.Second
+
+// extent is the byte range of a node in the file, from start to end exclusive.
+type extent struct {
+	start int64
+	end   int64
+}
+
+// prefetch is a block being fetched ahead of the reader.
+type prefetch struct {
+	ctx   context.Context
+	cid   cid.Cid
+	done  chan struct{}
+	block blocks.Block
+	node  ipld.Node
+	err   error
+	// fetched is when the block was retrieved, zero while it is queued or being fetched
+	fetched time.Time
+}
+
+// prefetcher walks the DAG ahead of the reader and fetches the blocks by a bounded number of workers.
+// The links of every node retrieved are queued before the pending siblings of the node, so the blocks are
+// fetched in DFS pre-order, which is the order the UnixFS reader consumes them. At most `window` blocks are
+// fetched or held without being consumed. The nodes are located in the file by the block sizes of UnixFS, when
+// the reader seeks, the blocks outside the reach of the window from the new position are evicted.
+type prefetcher struct {
+	session *titan.Session
+	window  int
+	workers int
+
+	lk      sync.Mutex
+	queue   *list.List // of *prefetch, not started yet
+	entries map[cid.Cid]*prefetch
+	active  int
+	// extents locates the nodes in the file, from the first node retrieved which is the root of the file
+	extents map[cid.Cid]extent
+	// offset is where the reader seeked to last, the blocks located before it are not prefetched
+	offset int64
+}
+
+func newPrefetcher(session *titan.Session, window, workers int) *prefetcher {
+	return &prefetcher{
+		session: session,
+		window:  window,
+		workers: workers,
+		queue:   list.New(),
+		entries: make(map[cid.Cid]*prefetch),
+		extents: make(map[cid.Cid]extent),
+	}
+}
+
+// retrieved locates the links of the node retrieved in the file and queues them.
+func (p *prefetcher) retrieved(ctx context.Context, c cid.Cid, node ipld.Node) {
+	p.lk.Lock()
+	p.locate(c, node)
+	p.lk.Unlock()
+
+	p.enqueue(ctx, linkCids(node))
+}
+
+// locate records the extents of the links of the node from its block sizes, must be called with lk held.
+func (p *prefetcher) locate(c cid.Cid, node ipld.Node) {
+	links := node.Links()
+	if len(links) == 0 {
+		return
+	}
+
+	fsNode, err := unixfs.ExtractFSNode(node)
+	if err != nil || fsNode.NumChildren() != len(links) {
+		return
+	}
+
+	ext, ok := p.extents[c]
+	if !ok {
+		if len(p.extents) > 0 {
+			// not linked from a node located, e.g. a block evicted and retrieved again
+			return
+		}
+		ext = extent{start: 0, end: int64(fsNode.FileSize())}
+		p.extents[c] = ext
+	}
+
+	start := ext.start
+	for i, link := range links {
+		end := start + int64(fsNode.BlockSize(i))
+		p.extents[link.Cid] = extent{start: start, end: end}
+		start = end
+	}
+}
+
+// seek evicts the blocks not overlapping the reach of the window from the offset the reader seeks to, they were
+// prefetched for the previous position and would keep the window full. The blocks not located are left to expire.
+func (p *prefetcher) seek(offset int64) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.offset = offset
+
+	// the reach is the span of the blocks held before the seek
+	var lo, hi int64 = -1, -1
+	for c := range p.entries {
+		ext, ok := p.extents[c]
+		if !ok {
+			continue
+		}
+		if lo < 0 || ext.start < lo {
+			lo = ext.start
+		}
+		if ext.end > hi {
+			hi = ext.end
+		}
+	}
+	reach := hi - lo
+
+	outside := func(c cid.Cid) bool {
+		ext, ok := p.extents[c]
+		return ok && (ext.end <= offset || ext.start >= offset+reach)
+	}
+
+	for e := p.queue.Front(); e != nil; {
+		next := e.Next()
+		if pf := e.Value.(*prefetch); outside(pf.cid) {
+			p.queue.Remove(e)
+		}
+		e = next
+	}
+
+	for c := range p.entries {
+		if outside(c) {
+			log.Debugf("evict prefetched block %s outside of offset %d", c, offset)
+			delete(p.entries, c)
+		}
+	}
+
+	p.spawn()
+}
+
+// enqueue queues the cids in order before the blocks already queued.
+func (p *prefetcher) enqueue(ctx context.Context, cids []cid.Cid) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	var mark *list.Element
+	for _, c := range cids {
+		if _, ok := p.entries[c]; ok {
+			continue
+		}
+		if ext, ok := p.extents[c]; ok && ext.end <= p.offset {
+			continue
+		}
+
+		pf := &prefetch{ctx: ctx, cid: c, done: make(chan struct{})}
+		p.entries[c] = pf
+
+		if mark == nil {
+			mark = p.queue.PushFront(pf)
+		} else {
+			mark = p.queue.InsertAfter(pf, mark)
+		}
+	}
+
+	p.spawn()
+}
+
+// spawn starts workers while there are queued blocks and room in the window, must be called with lk held.
+func (p *prefetcher) spawn() {
+	p.evict()
+
+	for p.active < p.workers && p.queue.Len() > 0 && len(p.entries)-p.queue.Len() < p.window {
+		p.active++
+		go p.work()
+	}
+}
+
+// work fetches the queued blocks until the queue is empty or the window is full.
+func (p *prefetcher) work() {
+	for {
+		p.lk.Lock()
+		p.evict()
+		if p.queue.Len() == 0 || len(p.entries)-p.queue.Len() >= p.window {
+			p.active--
+			p.lk.Unlock()
+			return
+		}
+		pf := p.queue.Remove(p.queue.Front()).(*prefetch)
+		p.lk.Unlock()
+
+		pf.block, pf.err = p.session.GetBlock(pf.ctx, pf.cid)
+		if pf.err == nil {
+			pf.node, pf.err = decodeNode(pf.ctx, pf.block)
+		}
+
+		p.lk.Lock()
+		pf.fetched = time.Now()
+		p.lk.Unlock()
+		close(pf.done)
+
+		if pf.err != nil {
+			log.Debugf("prefetch block %s failed: %v", pf.cid, pf.err)
+			continue
+		}
+
+		p.retrieved(pf.ctx, pf.cid, pf.node)
+	}
+}
+
+// take returns the prefetch of the cid and queues it first if it is not started yet. The prefetch leaves the
+// window, so it is only consumed once. It returns nil if the cid is not known to the prefetcher.
+func (p *prefetcher) take(c cid.Cid) *prefetch {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	pf, ok := p.entries[c]
+	if !ok {
+		return nil
+	}
+
+	delete(p.entries, c)
+
+	for e := p.queue.Front(); e != nil; e = e.Next() {
+		if e.Value.(*prefetch) == pf {
+			// not started yet, the caller fetches it
+			p.queue.Remove(e)
+			return nil
+		}
+	}
+
+	p.spawn()
+
+	return pf
+}
+
+// evict drops the blocks retrieved longer than prefetchTTL ago and never consumed, must be called with lk held.
+func (p *prefetcher) evict() {
+	deadline := time.Now().Add(-prefetchTTL)
+	for c, pf := range p.entries {
+		if !pf.fetched.IsZero() && pf.fetched.Before(deadline) {
+			log.Debugf("evict prefetched block %s not consumed", c)
+			delete(p.entries, c)
+		}
+	}
+}
+
+func linkCids(node ipld.Node) []cid.Cid {
+	links := node.Links()
+	cids := make([]cid.Cid, 0, len(links))
+	for _, link := range links {
+		cids = append(cids, link.Cid)
+	}
+	return cids
+}
@@ -0,0 +1,120 @@
+package merkledag
+
+import (
+	"context"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	ipld "github.com/ipfs/go-ipld-format"
+	"testing"
+	"time"
+)
+
+// held returns the number of blocks fetched or being fetched by the prefetcher, and the number of them located
+// from the offset on.
+func held(p *prefetcher, offset int64) (int, int) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	queued := make(map[*prefetch]bool)
+	for e := p.queue.Front(); e != nil; e = e.Next() {
+		queued[e.Value.(*prefetch)] = true
+	}
+
+	var n, ahead int
+	for c, pf := range p.entries {
+		if queued[pf] {
+			continue
+		}
+		n++
+		if ext, ok := p.extents[c]; ok && ext.start >= offset {
+			ahead++
+		}
+	}
+	return n, ahead
+}
+
+func TestPrefetchSeek(t *testing.T) {
+	const (
+		window = 16
+		offset = 3 << 20
+	)
+
+	network, err := titantest.NewNetwork()
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	defer network.Close()
+
+	// the leaves are linked by inner nodes, so the window holds blocks of several levels
+	root, _, err := network.AddRandomFile(4<<20, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+
+	s, err := titan.New(network.Config())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	defer s.Close()
+
+	session := s.NewSession(root)
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dag := NewDAGService(session, window, 4)
+
+	node, err := dag.Get(ctx, root)
+	if err != nil {
+		t.Fatalf("get root: %v", err)
+	}
+
+	waitFor := func(cond func() bool) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return true
+	}
+
+	// the window fills up with the blocks at the head of the file, nobody consumes them
+	if !waitFor(func() bool { n, _ := held(dag.prefetcher, 0); return n >= window }) {
+		n, _ := held(dag.prefetcher, 0)
+		t.Fatalf("held blocks = %d, want the window of %d", n, window)
+	}
+
+	// the reader seeks and walks down to the node holding the offset, as the UnixFS reader does
+	dag.SeekTo(offset)
+
+	var child ipld.Node
+	for _, link := range node.Links() {
+		dag.prefetcher.lk.Lock()
+		ext := dag.prefetcher.extents[link.Cid]
+		dag.prefetcher.lk.Unlock()
+
+		if ext.start <= offset && offset < ext.end {
+			if child, err = dag.Get(ctx, link.Cid); err != nil {
+				t.Fatalf("get node at offset: %v", err)
+			}
+			break
+		}
+	}
+	if child == nil {
+		t.Fatalf("no node located at offset %d", offset)
+	}
+
+	// the blocks prefetched for the head are evicted, so the window is filled again after the new position
+	// long before they would expire
+	if !waitFor(func() bool { _, ahead := held(dag.prefetcher, offset); return ahead >= window/2 }) {
+		n, ahead := held(dag.prefetcher, offset)
+		t.Errorf("held blocks = %d, %d of them after the offset, want the window after the offset", n, ahead)
+	}
+
+	if n, _ := held(dag.prefetcher, 0); n > window {
+		t.Errorf("held blocks = %d, more than the window of %d", n, window)
+	}
+}
@@ -0,0 +1,144 @@
+// Package metrics exposes the downloads as Prometheus metrics, it is optional and only depends on the events emitted
+// by the client, so it is enabled by observing them:
+//
+//	m := metrics.New(prometheus.DefaultRegisterer)
+//	client, err := titan.New(config.AddressOption(address), config.ObserverOption(m.Observe))
+//
+// The metrics of the edges are labelled by node id, so the number of series grows with the edges serving the client.
+package metrics
+
+import (
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "titan"
+
+const (
+	kindRange = "range"
+	kindBlock = "block"
+
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// Metrics holds the collectors of the downloads.
+type Metrics struct {
+	receivedBytes   *prometheus.CounterVec
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	natTraversals   *prometheus.CounterVec
+	retries         prometheus.Counter
+	proofs          *prometheus.CounterVec
+	downloads       *prometheus.CounterVec
+	downloadBytes   prometheus.Histogram
+}
+
+// New creates the collectors and registers them to the registerer, nil skips the registration.
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "edge_received_bytes_total",
+			Help:      "Bytes received from the edges.",
+		}, []string{"node_id", "kind"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "edge_requests_total",
+			Help:      "Requests sent to the edges.",
+		}, []string{"node_id", "kind", "outcome"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "edge_request_duration_seconds",
+			Help:      "Duration of the requests sent to the edges.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"kind", "outcome"}),
+		natTraversals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "nat_traversals_total",
+			Help:      "Attempts to reach the edges by their NAT type, the outcome is success or failure.",
+		}, []string{"nat_type", "outcome"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "range_retries_total",
+			Help:      "Ranges requested again after a failure.",
+		}),
+		proofs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proof_submissions_total",
+			Help:      "Submissions of workload reports to the schedulers.",
+		}, []string{"outcome"}),
+		downloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloads_total",
+			Help:      "Downloads finished.",
+		}, []string{"outcome"}),
+		downloadBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "download_bytes",
+			Help:      "Bytes received by a download.",
+			Buckets:   prometheus.ExponentialBuckets(1<<10, 4, 12),
+		}),
+	}
+
+	if registerer != nil {
+		registerer.MustRegister(m.Collectors()...)
+	}
+
+	return m
+}
+
+// Collectors returns all the collectors, to register them in another way.
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.receivedBytes,
+		m.requests,
+		m.requestDuration,
+		m.natTraversals,
+		m.retries,
+		m.proofs,
+		m.downloads,
+		m.downloadBytes,
+	}
+}
+
+// Observe updates the metrics by the event, it is an `event.Observer`.
+func (m *Metrics) Observe(e event.Event) {
+	switch e.Type {
+	case event.RangeCompleted:
+		m.request(e, kindRange)
+	case event.RangeFailed:
+		m.request(e, kindRange)
+	case event.BlockReceived:
+		m.request(e, kindBlock)
+	case event.BlockFailed:
+		m.request(e, kindBlock)
+	case event.RangeRetried:
+		m.retries.Inc()
+	case event.EdgeConnected:
+		m.natTraversals.WithLabelValues(e.NATType, outcome(e.Err)).Inc()
+	case event.ProofSubmitted:
+		m.proofs.WithLabelValues(outcome(e.Err)).Inc()
+	case event.Finished:
+		m.downloads.WithLabelValues(outcome(e.Err)).Inc()
+		m.downloadBytes.Observe(float64(e.Bytes))
+	}
+}
+
+func (m *Metrics) request(e event.Event, kind string) {
+	o := outcome(e.Err)
+
+	m.requests.WithLabelValues(e.NodeID, kind, o).Inc()
+	m.requestDuration.WithLabelValues(kind, o).Observe(e.Duration.Seconds())
+
+	if e.Err == nil {
+		m.receivedBytes.WithLabelValues(e.NodeID, kind).Add(float64(e.Bytes))
+	}
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
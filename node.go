@@ -0,0 +1,54 @@
+package titan
+
+import (
+	files "github.com/ipfs/go-ipfs-files"
+	"sync"
+)
+
+// sessionFile is a file of a download session, the proofs of work of the session are submitted when it is closed.
+type sessionFile struct {
+	files.File
+	once   sync.Once
+	notify func()
+}
+
+func (f *sessionFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.notify)
+	return err
+}
+
+// sessionDirectory is a directory of a download session, the proofs of work of the session are submitted
+// when it is closed, so the caller should close it after walking the whole tree.
+type sessionDirectory struct {
+	files.Directory
+	once   sync.Once
+	notify func()
+}
+
+func (d *sessionDirectory) Close() error {
+	err := d.Directory.Close()
+	d.once.Do(d.notify)
+	return err
+}
+
+func newSessionNode(node files.Node, notifyFunc func()) files.Node {
+	switch n := node.(type) {
+	case *files.Symlink:
+		// symlinks are fully retrieved once they are created, and must keep their type to be written as links
+		notifyFunc()
+		return node
+	case files.File:
+		return &sessionFile{File: n, notify: notifyFunc}
+	case files.Directory:
+		return &sessionDirectory{Directory: n, notify: notifyFunc}
+	default:
+		notifyFunc()
+		return node
+	}
+}
+
+var (
+	_ files.File      = (*sessionFile)(nil)
+	_ files.Directory = (*sessionDirectory)(nil)
+)
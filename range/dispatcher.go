@@ -2,11 +2,22 @@ package byterange
 
 import (
 	"context"
-	"github.com/eikenb/pipeat"
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/gnasnik/titan-sdk-go/selector"
 	"github.com/gnasnik/titan-sdk-go/titan"
 	"github.com/ipfs/go-cid"
 	"github.com/pkg/errors"
+	"io"
 	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRangeRetries is the number of times a range is retried before the download fails
+	maxRangeRetries = 3
+	// retryBackoff is the delay before the first retry of a range, it doubles on every retry
+	retryBackoff = 500 * time.Millisecond
 )
 
 type dispatcher struct {
@@ -17,9 +28,19 @@ type dispatcher struct {
 	todos       JobQueue
 	workers     chan worker
 	resp        chan response
-	titan       *titan.Service
-	writer      *pipeat.PipeWriterAt
-	reader      *pipeat.PipeReaderAt
+	session     *titan.Session
+	writer      io.WriterAt
+	// completed holds the indexes of jobs finished by a previous download, they are not fetched again
+	completed map[int]bool
+	// written is called after the data of a job has been written, if not nil
+	written func(index int)
+	// remaining is the size of data to be fetched
+	remaining int64
+	// done is closed when the dispatcher stops, err is nil if all data has been written, or the error it stopped by
+	done   chan struct{}
+	err    error
+	once   sync.Once
+	cancel context.CancelFunc
 }
 
 type worker struct {
@@ -27,6 +48,7 @@ type worker struct {
 }
 
 type response struct {
+	index  int
 	offset int64
 	data   []byte
 }
@@ -52,6 +74,11 @@ func (d *dispatcher) initialization() {
 			end = d.fileSize
 		}
 
+		if d.completed[int(i)] {
+			continue
+		}
+
+		d.remaining += end - start
 		d.todos.Push(&job{
 			index: int(i),
 			start: start,
@@ -62,6 +89,13 @@ func (d *dispatcher) initialization() {
 
 func (d *dispatcher) run(ctx context.Context) {
 	d.initialization()
+	if d.remaining == 0 {
+		d.finally(nil)
+		return
+	}
+
+	ctx, d.cancel = context.WithCancel(ctx)
+
 	d.writeData(ctx)
 
 	var (
@@ -76,48 +110,48 @@ func (d *dispatcher) run(ctx context.Context) {
 				go func() {
 					j, ok := d.todos.Pop()
 					if !ok {
-						d.workers <- w
+						// the worker retires, a job is only queued again by the worker fetching it, which is put back
 						return
 					}
 
 					if j.retry > 0 {
 						log.Debugf("pull data (retries: %d)", j.retry)
+						d.session.Emit(event.Event{Type: event.RangeRetried, Cid: d.cid, Start: j.start, End: j.end, Attempt: j.retry})
 					}
 
-					data, err := d.fetch(ctx, d.cid, j.start, j.end)
-					if err != nil {
-						log.Errorf("pull data failed: %v", err)
-
-						if j.retry < 3 {
-							j.retry++
-						} else {
-							// TODO: maybe remove bad nodes
-						}
+					dataLen := j.end - j.start
 
-						d.todos.PushFront(j)
-						d.workers <- w
-						return
+					data, err := d.fetch(ctx, d.cid, j.start, j.end)
+					if err == nil && int64(len(data)) < dataLen {
+						err = errors.Errorf("unexpected data size, want %d got %d", dataLen, len(data))
 					}
 
-					dataLen := j.end - j.start
+					if err != nil {
+						if !d.retry(ctx, j, err) {
+							return
+						}
 
-					if int64(len(data)) < dataLen {
-						log.Debugf("unexpected data size, want %d got %d", dataLen, len(data))
 						d.todos.PushFront(j)
 						d.workers <- w
 						return
 					}
 
-					d.workers <- w
-					d.resp <- response{
+					// the worker is released once the data is handed over, so a slow writer holds back the downloads
+					select {
+					case d.resp <- response{
+						index:  j.index,
 						data:   data[:dataLen],
 						offset: j.start,
+					}:
+					case <-ctx.Done():
+						return
 					}
+					d.workers <- w
 					finished <- dataLen
 				}()
 			case size := <-finished:
 				counter += size
-				if counter >= d.fileSize {
+				if counter >= d.remaining {
 					return
 				}
 			case <-ctx.Done():
@@ -131,23 +165,27 @@ func (d *dispatcher) run(ctx context.Context) {
 
 func (d *dispatcher) writeData(ctx context.Context) {
 	go func() {
-		defer d.finally()
-
 		var count int64
 		for {
 			select {
 			case r := <-d.resp:
 				_, err := d.writer.WriteAt(r.data, r.offset)
 				if err != nil {
-					log.Errorf("write data failed: %v", err)
-					continue
+					d.finally(errors.Errorf("write data failed: %v", err))
+					return
+				}
+
+				if d.written != nil {
+					d.written(r.index)
 				}
 
 				count += int64(len(r.data))
-				if count >= d.fileSize {
+				if count >= d.remaining {
+					d.finally(nil)
 					return
 				}
 			case <-ctx.Done():
+				d.finally(ctx.Err())
 				return
 			}
 		}
@@ -156,19 +194,64 @@ func (d *dispatcher) writeData(ctx context.Context) {
 }
 
 func (d *dispatcher) fetch(ctx context.Context, cid cid.Cid, start, end int64) ([]byte, error) {
-	_, data, err := d.titan.GetRange(ctx, cid, start, end)
+	_, data, err := d.session.GetRange(ctx, cid, start, end)
 	if err != nil {
-		return nil, errors.Errorf("get range failed: %v", err)
+		return nil, errors.Wrap(err, "get range failed")
 	}
 	return data, nil
 }
 
-func (d *dispatcher) finally() {
-	if err := d.titan.EndOfFile(); err != nil {
-		log.Errorf("end of file failed: %v", err)
+// retry waits before the job is requeued, it returns false if the job should not be retried. The dispatcher fails
+// with the error once the job has been retried maxRangeRetries times.
+func (d *dispatcher) retry(ctx context.Context, j *job, err error) bool {
+	// a ban is not a failure of the edge, the job waits for the ban to expire without using up its retries
+	if _, banned := selector.BannedUntil(err); !banned {
+		if j.retry >= maxRangeRetries {
+			d.finally(errors.Errorf("pull range %d-%d failed after %d retries: %v", j.start, j.end, j.retry, err))
+			return false
+		}
+		j.retry++
+	}
+
+	delay := retryDelay(j.retry, err)
+	log.Warnf("pull data failed, retry in %s: %v", delay, err)
+
+	return sleep(ctx, delay)
+}
+
+// finally stops the dispatcher, err is nil if all data has been written. Only the first call takes effect.
+func (d *dispatcher) finally(err error) {
+	d.once.Do(func() {
+		d.err = err
+		close(d.done)
+	})
+
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// retryDelay returns the delay before the retry of a range, which is the exponential backoff of the retries or
+// the time until the first ban expires if all the edges are banned.
+func retryDelay(retry int, err error) time.Duration {
+	delay := retryBackoff << retry
+	if until, ok := selector.BannedUntil(err); ok {
+		if d := time.Until(until); d > delay {
+			delay = d
+		}
 	}
+	return delay
+}
+
+// sleep waits for the duration, it returns false if the context is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-	if err := d.writer.Close(); err != nil {
-		log.Errorf("close write failed: %v", err)
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
@@ -0,0 +1,162 @@
+package byterange
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+// JournalSuffix is appended to the destination path to name the journal of a download.
+const JournalSuffix = ".journal"
+
+// journal records the progress of a download, so it can be resumed after the process crashed or the network was lost.
+type journal struct {
+	CID       string
+	FileSize  int64
+	RangeSize int64
+	// Completed holds the indexes of ranges that have been written to the destination file
+	Completed []int
+	// Proofs holds the proofs of work which haven't been submitted
+	Proofs []*titan.ProofParam
+}
+
+// matches reports whether the journal was written by a download of the same file with the same range size.
+func (j *journal) matches(cid cid.Cid, fileSize, rangeSize int64) bool {
+	return j.CID == cid.String() && j.FileSize == fileSize && j.RangeSize == rangeSize
+}
+
+func loadJournal(path string) (*journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var j journal
+	if err = json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+// save writes the journal to a temporary file then renames it, so a crash never leaves a partial journal.
+func (j *journal) save(path string) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Download retrieves the file and writes it to the path. The progress is recorded in a journal next to the file,
+// if the download is interrupted, calling Download again with the same path continues where it left off.
+// The proofs of work are submitted and the journal is removed once the whole file is written, the proofs failing to be
+// submitted are retried by the outbox of the service.
+func (r *Range) Download(ctx context.Context, cid cid.Cid, path string) error {
+	fileSize, err := r.fileSize(ctx, cid)
+	if err != nil {
+		r.session.CloseWithError(err)
+		return err
+	}
+
+	journalPath := path + JournalSuffix
+	jn, err := loadJournal(journalPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("load journal %s failed, start over: %v", journalPath, err)
+	}
+
+	if jn == nil || !jn.matches(cid, fileSize, r.size) {
+		jn = &journal{
+			CID:       cid.String(),
+			FileSize:  fileSize,
+			RangeSize: r.size,
+		}
+	}
+
+	completed := make(map[int]bool)
+	for _, index := range jn.Completed {
+		completed[index] = true
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		r.session.CloseWithError(err)
+		return err
+	}
+	defer file.Close()
+
+	if err = file.Truncate(fileSize); err != nil {
+		r.session.CloseWithError(err)
+		return err
+	}
+
+	r.session.AddProofs(jn.Proofs)
+
+	var lk sync.Mutex
+	persist := func() error {
+		lk.Lock()
+		defer lk.Unlock()
+
+		jn.Completed = jn.Completed[:0]
+		for index := range completed {
+			jn.Completed = append(jn.Completed, index)
+		}
+		sort.Ints(jn.Completed)
+		jn.Proofs = r.session.Proofs()
+
+		return jn.save(journalPath)
+	}
+
+	d := r.newDispatcher(cid, fileSize, file)
+	d.completed = completed
+	d.written = func(index int) {
+		if err := file.Sync(); err != nil {
+			log.Errorf("sync file failed: %v", err)
+			return
+		}
+
+		lk.Lock()
+		completed[index] = true
+		lk.Unlock()
+
+		if err := persist(); err != nil {
+			log.Errorf("save journal failed: %v", err)
+		}
+	}
+	d.run(ctx)
+
+	<-d.done
+
+	if d.err != nil {
+		if err = persist(); err != nil {
+			log.Errorf("save journal failed: %v", err)
+		}
+		// the proofs are kept in the journal, they are submitted by the download resuming it
+		err = errors.Errorf("download interrupted: %v", d.err)
+		r.session.Release(err)
+		return err
+	}
+
+	if err = r.session.Close(); err != nil && !titan.Queued(err) {
+		// the journal is kept with all ranges completed, so the next call only submits the proofs
+		return errors.Errorf("end of file failed: %v", err)
+	}
+
+	// the proofs queued in the outbox are retried by the service, the journal must not submit them again
+	if err != nil {
+		log.Warnf("submit proofs of work failed, retried in background: %v", err)
+	}
+
+	return os.Remove(journalPath)
+}
@@ -0,0 +1,205 @@
+package byterange
+
+import (
+	"bytes"
+	"context"
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestService(t *testing.T, network *titantest.Network) *titan.Service {
+	t.Helper()
+
+	s, err := titan.New(network.Config())
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestDownloadResume(t *testing.T) {
+	const rangeSize = 16 << 10
+
+	tests := []struct {
+		name string
+		// interruptAt is the index of the range whose request cancels the first download, -1 to not interrupt it
+		interruptAt int
+	}{
+		{"not interrupted", -1},
+		{"interrupted early", 3},
+		{"interrupted late", 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, err := titantest.NewNetwork()
+			if err != nil {
+				t.Fatalf("new network: %v", err)
+			}
+			defer network.Close()
+
+			root, _, err := network.AddRandomFile(256<<10, 4<<10)
+			if err != nil {
+				t.Fatalf("add file: %v", err)
+			}
+
+			car, _ := network.CAR(root)
+			total := (len(car) + rangeSize - 1) / rangeSize
+
+			s := newTestService(t, network)
+			path := filepath.Join(t.TempDir(), "file.car")
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			var completed []int
+			if tt.interruptAt >= 0 {
+				// a single worker requests the range only once the writer took the range before the last one,
+				// so the ranges before those two are in the journal when the download is cancelled
+				interrupted, interrupt := context.WithCancel(ctx)
+				interrupted = event.WithObserver(interrupted, func(e event.Event) {
+					if e.Type == event.RangeStarted && e.Start == int64(tt.interruptAt)*rangeSize {
+						interrupt()
+					}
+				})
+
+				r := New(s.NewSession(root, event.ObserverFromContext(interrupted)), rangeSize, 1, false)
+				if err = r.Download(interrupted, root, path); err == nil {
+					t.Fatalf("interrupted download succeeded")
+				}
+
+				jn, err := loadJournal(path + JournalSuffix)
+				if err != nil {
+					t.Fatalf("load journal: %v", err)
+				}
+				if !jn.matches(root, int64(len(car)), rangeSize) {
+					t.Fatalf("journal of %s(%d bytes) does not match the download", jn.CID, jn.FileSize)
+				}
+				if len(jn.Completed) < tt.interruptAt-1 {
+					t.Errorf("completed ranges = %v, want at least %d", jn.Completed, tt.interruptAt-1)
+				}
+				if len(jn.Proofs) == 0 {
+					t.Errorf("no proof of work kept in the journal")
+				}
+				completed = jn.Completed
+			}
+
+			var (
+				lk     sync.Mutex
+				ranges = make(map[int64]bool)
+			)
+			observer := func(e event.Event) {
+				// the first request gets the size of the file
+				if e.Type == event.RangeStarted && e.End != 1<<10 {
+					lk.Lock()
+					ranges[e.Start] = true
+					lk.Unlock()
+				}
+			}
+
+			r := New(s.NewSession(root, observer), rangeSize, 4, false)
+			if err = r.Download(ctx, root, path); err != nil {
+				t.Fatalf("download: %v", err)
+			}
+
+			for _, index := range completed {
+				if ranges[int64(index)*rangeSize] {
+					t.Errorf("range %d in the journal is requested again", index)
+				}
+			}
+			if len(ranges) != total-len(completed) {
+				t.Errorf("requested ranges = %d, want %d", len(ranges), total-len(completed))
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read file: %v", err)
+			}
+			if !bytes.Equal(got, car) {
+				t.Errorf("downloaded file does not match the car file")
+			}
+
+			if _, err = os.Stat(path + JournalSuffix); !os.IsNotExist(err) {
+				t.Errorf("journal is not removed: %v", err)
+			}
+			if len(network.Scheduler().Reports()) == 0 {
+				t.Errorf("no workload report submitted")
+			}
+		})
+	}
+}
+
+func TestDownloadSubmitOnce(t *testing.T) {
+	const rangeSize = 16 << 10
+
+	network, err := titantest.NewNetwork(titantest.WithEdges(titantest.EdgeOptions{}))
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	defer network.Close()
+
+	root, _, err := network.AddRandomFile(256<<10, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+
+	s := newTestService(t, network)
+	path := filepath.Join(t.TempDir(), "file.car")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	interrupted, interrupt := context.WithCancel(ctx)
+	interrupted = event.WithObserver(interrupted, func(e event.Event) {
+		if e.Type == event.RangeStarted && e.Start == 8*rangeSize {
+			interrupt()
+		}
+	})
+
+	r := New(s.NewSession(root, event.ObserverFromContext(interrupted)), rangeSize, 1, false)
+	if err = r.Download(interrupted, root, path); err == nil {
+		t.Fatalf("interrupted download succeeded")
+	}
+
+	// the report of the proofs restored from the journal and of the resumed download fails once, the outbox retries it
+	network.Scheduler().FailReports(1)
+
+	for i := 0; i < 2; i++ {
+		r = New(s.NewSession(root), rangeSize, 4, false)
+		if err = r.Download(ctx, root, path); err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+
+		if _, err = os.Stat(path + JournalSuffix); !os.IsNotExist(err) {
+			t.Errorf("download %d: journal is not removed: %v", i, err)
+		}
+	}
+
+	if err = s.FlushReports(ctx); err != nil {
+		t.Fatalf("flush reports: %v", err)
+	}
+
+	reports := network.Scheduler().Reports()
+	if len(reports) == 0 {
+		t.Fatalf("no workload report submitted")
+	}
+
+	// the proofs of every token are submitted in a single report
+	tokens := make(map[string]int)
+	for _, report := range reports {
+		tokens[report.TokenID]++
+	}
+	for tokenID, n := range tokens {
+		if n != 1 {
+			t.Errorf("reports of token %s = %d, want 1", tokenID, n)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package byterange
+
+import (
+	"context"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+// File is a random-access handle of the CAR file of a cid, the data is retrieved on demand by range requests.
+// The requests are aligned to the range size, the last chunk read is kept, and the next chunk is prefetched while
+// the file is read sequentially, so small reads do not turn into a request each.
+type File struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	session   *titan.Session
+	cid       cid.Cid
+	size      int64
+	rangeSize int64
+
+	lk     sync.Mutex
+	offset int64
+
+	clk sync.Mutex
+	// last is the chunk read last, ahead is the chunk after it being prefetched
+	last  *chunk
+	ahead *chunk
+}
+
+// chunk is the data of the file in [index*rangeSize, (index+1)*rangeSize), done is closed once it is retrieved.
+type chunk struct {
+	index int64
+	done  chan struct{}
+	data  []byte
+	err   error
+}
+
+// OpenCAR opens the CAR file of the cid for random access, nothing but the size of file is retrieved until it is read.
+// The offsets are the offsets in the CAR file, not in the UnixFS file the CAR holds.
+func (r *Range) OpenCAR(ctx context.Context, cid cid.Cid) (*File, error) {
+	fileSize, err := r.fileSize(ctx, cid)
+	if err != nil {
+		r.session.CloseWithError(err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &File{
+		ctx:       ctx,
+		cancel:    cancel,
+		session:   r.session,
+		cid:       cid,
+		size:      fileSize,
+		rangeSize: r.size,
+	}, nil
+}
+
+// Size returns the size of the file.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// ReadAt reads len(p) bytes from the file starting at offset off, the data is read from the chunks covering it.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("negative offset: %d", off)
+	}
+
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	want := int64(len(p))
+	if off+want > f.size {
+		want = f.size - off
+	}
+
+	var n int64
+	for n < want {
+		index := (off + n) / f.rangeSize
+
+		data, err := f.chunk(index)
+		if err != nil {
+			return int(n), err
+		}
+
+		n += int64(copy(p[n:want], data[off+n-index*f.rangeSize:]))
+	}
+
+	if n < int64(len(p)) {
+		return int(n), io.EOF
+	}
+
+	return int(n), nil
+}
+
+// chunk returns the data of the chunk, from the last chunk or the prefetched one if it is the chunk. The chunk
+// following it is prefetched if the chunk follows the last one.
+func (f *File) chunk(index int64) ([]byte, error) {
+	f.clk.Lock()
+
+	sequential := f.last != nil && f.last.index+1 == index
+
+	var c *chunk
+	switch {
+	case f.last != nil && f.last.index == index:
+		c = f.last
+	case f.ahead != nil && f.ahead.index == index:
+		c, f.ahead = f.ahead, nil
+	default:
+		c = f.start(index)
+	}
+	f.last = c
+
+	if next := index + 1; sequential && next*f.rangeSize < f.size && (f.ahead == nil || f.ahead.index != next) {
+		f.ahead = f.start(next)
+	}
+
+	f.clk.Unlock()
+
+	select {
+	case <-c.done:
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+
+	if c.err != nil {
+		// the chunk is retrieved again by the next read
+		f.clk.Lock()
+		if f.last == c {
+			f.last = nil
+		}
+		f.clk.Unlock()
+
+		return nil, c.err
+	}
+
+	return c.data, nil
+}
+
+// start retrieves the chunk in background, must be called with clk held.
+func (f *File) start(index int64) *chunk {
+	c := &chunk{index: index, done: make(chan struct{})}
+
+	start := index * f.rangeSize
+	end := start + f.rangeSize
+	if end > f.size {
+		end = f.size
+	}
+
+	go func() {
+		defer close(c.done)
+		c.data, c.err = f.fetch(start, end)
+	}()
+
+	return c
+}
+
+// fetch retrieves the data in range [start, end) of the file, retries if the edge failed to serve it.
+func (f *File) fetch(start, end int64) ([]byte, error) {
+	var lastErr error
+	for retry := 0; retry <= maxRangeRetries; retry++ {
+		if err := f.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		_, data, err := f.session.GetRange(f.ctx, f.cid, start, end)
+		if err == nil && int64(len(data)) < end-start {
+			err = errors.Errorf("unexpected data size, want %d got %d", end-start, len(data))
+		}
+
+		if err == nil {
+			return data[:end-start], nil
+		}
+
+		lastErr = errors.Wrap(err, "get range failed")
+		if retry < maxRangeRetries && !sleep(f.ctx, retryDelay(retry, err)) {
+			return nil, f.ctx.Err()
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, errors.Errorf("negative position: %d", offset)
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Close stops the prefetching, submits the proofs of work of the file and closes the session.
+func (f *File) Close() error {
+	f.cancel()
+	return f.session.Close()
+}
+
+var (
+	_ io.ReadSeekCloser = (*File)(nil)
+	_ io.ReaderAt       = (*File)(nil)
+)
@@ -6,53 +6,99 @@ import (
 	"github.com/gnasnik/titan-sdk-go/titan"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
 	"io"
 )
 
 var log = logging.Logger("range")
 
+// sizeProbeEnd is the end of the range retrieved to get the size of the file
+const sizeProbeEnd = 1 << 10
+
 type Range struct {
-	titan       *titan.Service
+	session     *titan.Session
 	size        int64
 	concurrency int
+	verify      bool
 }
 
-func New(service *titan.Service, size int64, concurrency int) *Range {
+func New(session *titan.Session, size int64, concurrency int, verify bool) *Range {
 	return &Range{
-		titan:       service,
+		session:     session,
 		size:        size,
 		concurrency: concurrency,
+		verify:      verify,
 	}
 }
 
 func (r *Range) GetFile(ctx context.Context, cid cid.Cid) (int64, io.ReadCloser, error) {
-	var (
-		start int64
-		size  int64 = 1 << 10 // 1 KiB
-	)
-
-	fileSize, _, err := r.titan.GetRange(ctx, cid, start, size)
+	fileSize, err := r.fileSize(ctx, cid)
 	if err != nil {
-		log.Errorf("get range failed: %v", err)
+		r.session.CloseWithError(err)
 		return 0, nil, err
 	}
 
 	reader, writer, err := pipeat.Pipe()
 	if err != nil {
+		r.session.CloseWithError(err)
 		return 0, nil, err
 	}
 
-	(&dispatcher{
+	d := r.newDispatcher(cid, fileSize, writer)
+	d.run(ctx)
+
+	go func() {
+		<-d.done
+
+		var cause error
+		if d.err != nil {
+			cause = errors.Errorf("download interrupted: %v", d.err)
+		}
+
+		if err := r.session.CloseWithError(cause); err != nil {
+			log.Errorf("close session failed: %v", err)
+		}
+
+		if cause != nil {
+			// the reader gets the error instead of a truncated file
+			writer.CloseWithError(cause)
+			return
+		}
+
+		if err := writer.Close(); err != nil {
+			log.Errorf("close write failed: %v", err)
+		}
+	}()
+
+	if r.verify {
+		cache := &rangeCache{ctx: ctx, session: r.session, cid: cid, fileSize: fileSize, rangeSize: r.size}
+		return fileSize, newVerifiedReader(ctx, cid, reader, cache), nil
+	}
+
+	return fileSize, reader, nil
+}
+
+func (r *Range) newDispatcher(cid cid.Cid, fileSize int64, writer io.WriterAt) *dispatcher {
+	return &dispatcher{
 		cid:         cid,
 		fileSize:    fileSize,
 		rangeSize:   r.size,
 		concurrency: r.concurrency,
-		titan:       r.titan,
-		reader:      reader,
+		session:     r.session,
 		writer:      writer,
 		workers:     make(chan worker, r.concurrency),
 		resp:        make(chan response, r.concurrency),
-	}).run(ctx)
+		done:        make(chan struct{}),
+	}
+}
 
-	return fileSize, reader, nil
+// fileSize retrieves the first bytes of the file to get the size of it.
+func (r *Range) fileSize(ctx context.Context, cid cid.Cid) (int64, error) {
+	fileSize, _, err := r.session.GetRange(ctx, cid, 0, sizeProbeEnd)
+	if err != nil {
+		log.Errorf("get range failed: %v", err)
+		return 0, err
+	}
+
+	return fileSize, nil
 }
@@ -0,0 +1,387 @@
+package byterange
+
+import (
+	"context"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+// DecodeFile retrieves the CAR file of the cid by range requests and decodes it as the ranges arrive in order,
+// the returned reader is the UnixFS file reconstructed from the blocks, and the size is the size of that file.
+// Every block is verified against its cid and must be linked from the root. Only the ranges not yet consumed
+// by the reader are held in memory, so the CAR file is never stored as a whole.
+func (r *Range) DecodeFile(ctx context.Context, cid cid.Cid) (int64, io.ReadCloser, error) {
+	carSize, err := r.fileSize(ctx, cid)
+	if err != nil {
+		r.session.CloseWithError(err)
+		return 0, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	pr, pw := io.Pipe()
+	writer := &orderedWriter{
+		w:       pw,
+		pending: make(map[int64][]byte),
+	}
+
+	d := r.newDispatcher(cid, carSize, writer)
+	d.run(ctx)
+
+	go func() {
+		<-d.done
+
+		if d.err != nil {
+			pw.CloseWithError(errors.Errorf("download interrupted: %v", d.err))
+			return
+		}
+
+		pw.Close()
+	}()
+
+	dag := newStreamDAG(r.session)
+	go dag.consume(ctx, cid, pr)
+
+	node, err := dag.Get(ctx, cid)
+	if err != nil {
+		cancel()
+		pr.Close()
+		r.session.CloseWithError(err)
+		return 0, nil, err
+	}
+
+	fileNode, err := unixfile.NewUnixfsFile(ctx, dag, node)
+	if err != nil {
+		cancel()
+		pr.Close()
+		r.session.CloseWithError(err)
+		return 0, nil, err
+	}
+
+	file, ok := fileNode.(files.File)
+	if !ok {
+		fileNode.Close()
+		cancel()
+		pr.Close()
+		err := errors.Errorf("the merkle dag is not file")
+		r.session.CloseWithError(err)
+		return 0, nil, err
+	}
+
+	size, err := file.Size()
+	if err != nil {
+		file.Close()
+		cancel()
+		pr.Close()
+		r.session.CloseWithError(err)
+		return 0, nil, err
+	}
+
+	return size, &decodedFile{File: file, source: pr, session: r.session, cancel: cancel}, nil
+}
+
+// decodedFile submits the proofs of work once the file is read to the end, and stops the download and closes
+// the session when closed.
+type decodedFile struct {
+	files.File
+	source  io.Closer
+	session *titan.Session
+	cancel  context.CancelFunc
+}
+
+func (f *decodedFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err == io.EOF {
+		// the session keeps no proof once submitted, so reading past the end does not submit twice
+		if e := f.session.EndOfFile(); e != nil {
+			log.Errorf("end of file failed: %v", e)
+		}
+	}
+
+	return n, err
+}
+
+func (f *decodedFile) Close() error {
+	f.cancel()
+	f.source.Close()
+	if err := f.session.Close(); err != nil {
+		log.Errorf("close session failed: %v", err)
+	}
+	return f.File.Close()
+}
+
+// orderedWriter turns the out-of-order writes of the dispatcher into an in-order stream. The ranges written ahead of
+// the stream are held in memory until the gap before them is filled, the in-order writes block until the stream is
+// consumed, which in turn holds back the workers of the dispatcher.
+type orderedWriter struct {
+	lk      sync.Mutex
+	w       *io.PipeWriter
+	offset  int64
+	pending map[int64][]byte
+}
+
+func (o *orderedWriter) WriteAt(p []byte, off int64) (int, error) {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	if off < o.offset {
+		return 0, errors.Errorf("range at %d is already written", off)
+	}
+
+	o.pending[off] = p
+
+	for {
+		data, ok := o.pending[o.offset]
+		if !ok {
+			break
+		}
+
+		delete(o.pending, o.offset)
+
+		if _, err := o.w.Write(data); err != nil {
+			return 0, err
+		}
+
+		o.offset += int64(len(data))
+	}
+
+	return len(p), nil
+}
+
+// streamBlocks bounds the blocks decoded ahead of the DAG walk, the stream is read no further until the walk takes one
+const streamBlocks = 256
+
+// streamDAG is a DAG service over a CAR stream, the blocks are decoded as they arrive and released once they are
+// retrieved. A block requested again after being released, because it is linked more than once, is retrieved from
+// the edges by the session, so is a block requested while the buffer is full of blocks the walk does not need yet.
+type streamDAG struct {
+	session *titan.Session
+	// limit is the number of blocks held at most
+	limit int
+
+	lk       sync.Mutex
+	cond     *sync.Cond
+	nodes    map[cid.Cid]ipld.Node
+	released map[cid.Cid]struct{}
+	// err is set when the stream ends, io.EOF if all blocks were read
+	err error
+}
+
+func newStreamDAG(session *titan.Session) *streamDAG {
+	d := &streamDAG{
+		session:  session,
+		limit:    streamBlocks,
+		nodes:    make(map[cid.Cid]ipld.Node),
+		released: make(map[cid.Cid]struct{}),
+	}
+	d.cond = sync.NewCond(&d.lk)
+	return d
+}
+
+// consume reads the blocks of the CAR stream until it ends, the blocks must be linked from the root.
+func (d *streamDAG) consume(ctx context.Context, root cid.Cid, r io.ReadCloser) {
+	done := make(chan struct{})
+	defer close(done)
+
+	// wakes up readBlocks waiting for room in the buffer
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.lk.Lock()
+			d.cond.Broadcast()
+			d.lk.Unlock()
+		case <-done:
+		}
+	}()
+
+	err := d.readBlocks(ctx, root, r)
+	if err != io.EOF {
+		// stop the download as no block can be decoded anymore
+		r.Close()
+	}
+
+	d.lk.Lock()
+	d.err = err
+	d.cond.Broadcast()
+	d.lk.Unlock()
+}
+
+func (d *streamDAG) readBlocks(ctx context.Context, root cid.Cid, r io.Reader) error {
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return errors.Errorf("read car header: %v", err)
+	}
+
+	if !containsCid(br.Roots, root) {
+		return errors.Errorf("car roots %v do not contain %s", br.Roots, root)
+	}
+
+	seen := make(map[cid.Cid]struct{})
+	pending := map[cid.Cid]struct{}{root: {}}
+
+	for {
+		// BlockReader checks the block data against its cid
+		block, err := br.Next()
+		if err == io.EOF {
+			if len(pending) > 0 {
+				return errors.Errorf("incomplete dag, %d blocks are missing", len(pending))
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return errors.Errorf("verify block: %v", err)
+		}
+
+		if _, ok := seen[block.Cid()]; ok {
+			continue
+		}
+
+		if _, ok := pending[block.Cid()]; !ok {
+			return errors.Errorf("unexpected block %s which is not linked from %s", block.Cid(), root)
+		}
+
+		node, err := ipldlegacy.DecodeNode(ctx, block)
+		if err != nil {
+			return errors.Errorf("decode block %s: %v", block.Cid(), err)
+		}
+
+		delete(pending, block.Cid())
+		seen[block.Cid()] = struct{}{}
+
+		for _, link := range node.Links() {
+			if _, ok := seen[link.Cid]; !ok {
+				pending[link.Cid] = struct{}{}
+			}
+		}
+
+		d.lk.Lock()
+		for len(d.nodes) >= d.limit && ctx.Err() == nil {
+			d.cond.Wait()
+		}
+		// the block may have been retrieved by the session while the buffer was full
+		if _, ok := d.released[block.Cid()]; !ok {
+			d.nodes[block.Cid()] = node
+		}
+		d.cond.Broadcast()
+		d.lk.Unlock()
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Get waits for the block to arrive in the stream.
+func (d *streamDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.lk.Lock()
+			d.cond.Broadcast()
+			d.lk.Unlock()
+		case <-done:
+		}
+	}()
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	for {
+		if node, ok := d.nodes[c]; ok {
+			delete(d.nodes, c)
+			d.released[c] = struct{}{}
+			// makes room for the next block of the stream
+			d.cond.Broadcast()
+			return node, nil
+		}
+
+		_, released := d.released[c]
+		if !released && d.err == nil && len(d.nodes) >= d.limit {
+			// the stream waits for the walk to take a buffered block, which may come after this one
+			d.released[c] = struct{}{}
+			released = true
+		}
+
+		if released {
+			d.lk.Unlock()
+			node, err := d.getFromSession(ctx, c)
+			d.lk.Lock()
+			return node, err
+		}
+
+		if d.err != nil {
+			if d.err == io.EOF {
+				return nil, errors.Errorf("block %s not found in the car stream", c)
+			}
+			return nil, d.err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		d.cond.Wait()
+	}
+}
+
+func (d *streamDAG) getFromSession(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	block, err := d.session.GetBlock(ctx, c)
+	if err != nil {
+		return nil, errors.Errorf("get block %s: %v", c, err)
+	}
+
+	return ipldlegacy.DecodeNode(ctx, block)
+}
+
+// GetMany gets the nodes one by one in the order of the cids, which is how they arrive in a DFS-ordered stream.
+func (d *streamDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+
+	go func() {
+		defer close(out)
+
+		for _, c := range cids {
+			node, err := d.Get(ctx, c)
+			select {
+			case out <- &ipld.NodeOption{Node: node, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Add unimplemented
+func (d *streamDAG) Add(ctx context.Context, node ipld.Node) error {
+	return errors.New("unimplemented")
+}
+
+// AddMany unimplemented
+func (d *streamDAG) AddMany(ctx context.Context, nodes []ipld.Node) error {
+	return errors.New("unimplemented")
+}
+
+// Remove unimplemented
+func (d *streamDAG) Remove(ctx context.Context, cid cid.Cid) error {
+	return errors.New("unimplemented")
+}
+
+// RemoveMany unimplemented
+func (d *streamDAG) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	return errors.New("unimplemented")
+}
+
+var _ ipld.DAGService = (*streamDAG)(nil)
@@ -0,0 +1,84 @@
+package byterange
+
+import (
+	"bytes"
+	"context"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	files "github.com/ipfs/go-ipfs-files"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStreamDAGBound(t *testing.T) {
+	const limit = 16
+
+	network, err := titantest.NewNetwork()
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	defer network.Close()
+
+	// about 1000 blocks, far more than the buffer holds
+	root, data, err := network.AddRandomFile(4<<20, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+	car, _ := network.CAR(root)
+
+	s := newTestService(t, network)
+	session := s.NewSession(root)
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dag := newStreamDAG(session)
+	dag.limit = limit
+	go dag.consume(ctx, root, io.NopCloser(bytes.NewReader(car)))
+
+	node, err := dag.Get(ctx, root)
+	if err != nil {
+		t.Fatalf("get root: %v", err)
+	}
+	fileNode, err := unixfile.NewUnixfsFile(ctx, dag, node)
+	if err != nil {
+		t.Fatalf("new unixfs file: %v", err)
+	}
+	file, ok := fileNode.(files.File)
+	if !ok {
+		t.Fatalf("the merkle dag is not file")
+	}
+	defer file.Close()
+
+	var (
+		got  bytes.Buffer
+		peak int
+		buf  = make([]byte, 4<<10)
+	)
+	for {
+		n, err := file.Read(buf)
+		got.Write(buf[:n])
+
+		dag.lk.Lock()
+		if len(dag.nodes) > peak {
+			peak = len(dag.nodes)
+		}
+		dag.lk.Unlock()
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read file: %v", err)
+		}
+	}
+
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("decoded file does not match the data")
+	}
+	if peak > limit {
+		t.Errorf("peak buffered blocks = %d, want at most %d", peak, limit)
+	}
+}
@@ -0,0 +1,236 @@
+package byterange
+
+import (
+	"bytes"
+	"context"
+	"github.com/gnasnik/titan-sdk-go/titan"
+	"github.com/ipfs/go-cid"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/pkg/errors"
+	"io"
+
+	// registers the dag-pb decoder used by ipldlegacy.DecodeNode
+	_ "github.com/ipfs/go-merkledag"
+)
+
+// verifiedReader reads a CAR stream and only hands out bytes that were verified against the DAG of the root cid,
+// so a block whose hash does not match its cid, or which is not linked from the root, never reaches the caller.
+type verifiedReader struct {
+	*io.PipeReader
+	source io.ReadCloser
+}
+
+// newVerifiedReader verifies the CAR stream of the root, the verified bytes are also written to the cache.
+func newVerifiedReader(ctx context.Context, root cid.Cid, source io.ReadCloser, cache io.Writer) *verifiedReader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(verifyCAR(ctx, root, source, io.MultiWriter(pw, cache)))
+	}()
+
+	return &verifiedReader{
+		PipeReader: pr,
+		source:     source,
+	}
+}
+
+func (v *verifiedReader) Close() error {
+	v.PipeReader.Close()
+	return v.source.Close()
+}
+
+// recorder keeps the bytes read from the reader until they are flushed.
+type recorder struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (rc *recorder) Read(p []byte) (int, error) {
+	n, err := rc.r.Read(p)
+	rc.buf.Write(p[:n])
+	return n, err
+}
+
+func (rc *recorder) flush(w io.Writer) error {
+	_, err := rc.buf.WriteTo(w)
+	return err
+}
+
+// verifyCAR walks the blocks of the CAR stream in order, checks the hash of every block and that every block is
+// reachable from the root, then writes the verified bytes to w. The blocks are expected in DFS pre-order,
+// which is how the edges generate CAR files.
+func verifyCAR(ctx context.Context, root cid.Cid, r io.Reader, w io.Writer) error {
+	rc := &recorder{r: r}
+
+	br, err := carv2.NewBlockReader(rc)
+	if err != nil {
+		return errors.Errorf("read car header: %v", err)
+	}
+
+	if !containsCid(br.Roots, root) {
+		return errors.Errorf("car roots %v do not contain %s", br.Roots, root)
+	}
+
+	var header carv2.Header
+	if br.Version == 2 {
+		if _, err = header.ReadFrom(bytes.NewReader(rc.buf.Bytes()[carv2.PragmaSize:])); err != nil {
+			return errors.Errorf("read carv2 header: %v", err)
+		}
+	}
+
+	if err = rc.flush(w); err != nil {
+		return err
+	}
+
+	seen := make(map[cid.Cid]struct{})
+	pending := map[cid.Cid]struct{}{root: {}}
+
+	for {
+		// BlockReader checks the block data against its cid
+		block, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Errorf("verify block: %v", err)
+		}
+
+		if _, ok := seen[block.Cid()]; !ok {
+			if _, ok = pending[block.Cid()]; !ok {
+				return errors.Errorf("unexpected block %s which is not linked from %s", block.Cid(), root)
+			}
+
+			node, err := ipldlegacy.DecodeNode(ctx, block)
+			if err != nil {
+				return errors.Errorf("decode block %s: %v", block.Cid(), err)
+			}
+
+			delete(pending, block.Cid())
+			seen[block.Cid()] = struct{}{}
+
+			for _, link := range node.Links() {
+				if _, ok := seen[link.Cid]; !ok {
+					pending[link.Cid] = struct{}{}
+				}
+			}
+		}
+
+		if err = rc.flush(w); err != nil {
+			return err
+		}
+	}
+
+	if len(pending) > 0 {
+		return errors.Errorf("incomplete dag, %d blocks are missing", len(pending))
+	}
+
+	if err = rc.flush(w); err != nil {
+		return err
+	}
+
+	if err = verifyIndex(header, rc); err != nil {
+		return err
+	}
+
+	return rc.flush(w)
+}
+
+// verifyIndex checks the data following the blocks is the index of a CARv2 and nothing else, a CARv1 ends with
+// its last block. The index only speeds up the lookups of the blocks, it is checked to be well-formed, not to
+// match the blocks.
+func verifyIndex(header carv2.Header, r io.Reader) error {
+	if header.HasIndex() {
+		dataEnd := header.DataOffset + header.DataSize
+		if header.IndexOffset < dataEnd {
+			return errors.Errorf("carv2 index offset %d overlaps the data ending at %d", header.IndexOffset, dataEnd)
+		}
+
+		if _, err := io.CopyN(io.Discard, r, int64(header.IndexOffset-dataEnd)); err != nil {
+			return errors.Errorf("read carv2 index padding: %v", err)
+		}
+
+		if _, err := index.ReadFrom(r); err != nil {
+			return errors.Errorf("read carv2 index: %v", err)
+		}
+	}
+
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return errors.Errorf("unexpected %d bytes after the car data", n)
+	}
+
+	return nil
+}
+
+func containsCid(cids []cid.Cid, c cid.Cid) bool {
+	for _, item := range cids {
+		if item.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeCache caches the ranges the dispatcher requests, and the range probing the size of the file, from the verified
+// bytes of the CAR stream, so the cache never serves bytes which did not pass the verification.
+type rangeCache struct {
+	ctx       context.Context
+	session   *titan.Session
+	cid       cid.Cid
+	fileSize  int64
+	rangeSize int64
+
+	// head holds the first bytes until the size probe is cached
+	head   []byte
+	probed bool
+	// buf holds the verified bytes from start on, the start of the range being filled
+	buf    []byte
+	start  int64
+	offset int64
+}
+
+func (c *rangeCache) Write(p []byte) (int, error) {
+	if !c.probed {
+		c.head = append(c.head, p...)
+		if last := c.last(sizeProbeEnd); int64(len(c.head)) > last {
+			c.session.CacheRange(c.ctx, c.cid, 0, sizeProbeEnd, c.fileSize, c.head[:last+1])
+			c.head, c.probed = nil, true
+		}
+	}
+
+	c.buf = append(c.buf, p...)
+	c.offset += int64(len(p))
+
+	// a range is requested with the end at the start of the next one, the byte at the end is part of both
+	for c.start < c.fileSize {
+		end := c.start + c.rangeSize
+		if end > c.fileSize {
+			end = c.fileSize
+		}
+
+		last := c.last(end)
+		if c.offset <= last {
+			break
+		}
+
+		c.session.CacheRange(c.ctx, c.cid, c.start, end, c.fileSize, c.buf[:last-c.start+1])
+		c.buf = c.buf[end-c.start:]
+		c.start = end
+	}
+
+	return len(p), nil
+}
+
+// last returns the offset of the last byte served for a range ending at end.
+func (c *rangeCache) last(end int64) int64 {
+	if end >= c.fileSize {
+		return c.fileSize - 1
+	}
+	return end
+}
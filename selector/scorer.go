@@ -0,0 +1,235 @@
+package selector
+
+import (
+	"fmt"
+	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("selector")
+
+const (
+	// ewmaAlpha is the weight of the latest sample in the moving averages.
+	ewmaAlpha = 0.3
+	// maxConsecutiveFailures is the number of failures in a row after which an edge is banned.
+	maxConsecutiveFailures = 3
+	minBanDuration         = 10 * time.Second
+	maxBanDuration         = 5 * time.Minute
+)
+
+// Stats is the health of an edge observed by the client.
+type Stats struct {
+	NodeID string
+	// Latency is the moving average of the request duration.
+	Latency time.Duration
+	// Throughput is the moving average of the download speed in bytes per second.
+	Throughput float64
+	// ErrorRate is the moving average of the failed requests, between 0 and 1.
+	ErrorRate           float64
+	Requests            int64
+	Failures            int64
+	ConsecutiveFailures int
+	// Outstanding is the number of requests in flight.
+	Outstanding int
+	// Bans is the number of times the edge was banned, each ban lasts twice as long as the previous one.
+	Bans        int
+	BannedUntil time.Time
+}
+
+// Banned reports whether the edge is banned at the time.
+func (s Stats) Banned(now time.Time) bool {
+	return now.Before(s.BannedUntil)
+}
+
+// Score estimates how fast the edge serves the data, edges without samples are scored by the given default.
+func (s Stats) Score(defaultThroughput float64) float64 {
+	throughput := s.Throughput
+	if s.Requests == s.Failures {
+		throughput = defaultThroughput
+	}
+	return throughput * (1 - s.ErrorRate)
+}
+
+// BannedError is returned by Select when all the edges are banned.
+type BannedError struct {
+	// Until is when the first ban expires
+	Until time.Time
+}
+
+func (e *BannedError) Error() string {
+	return fmt.Sprintf("all edges are banned for %s", time.Until(e.Until).Round(time.Second))
+}
+
+// BannedUntil returns when the first ban expires if the error is caused by all the edges being banned.
+func BannedUntil(err error) (time.Time, bool) {
+	var banned *BannedError
+	if !errors.As(err, &banned) {
+		return time.Time{}, false
+	}
+	return banned.Until, true
+}
+
+// Scorer tracks the health of the edges of a download and picks edges with the strategy.
+// Edges failing repeatedly are banned for a while, so they stop getting requests.
+type Scorer struct {
+	lk       sync.Mutex
+	strategy Strategy
+	stats    map[string]*Stats
+}
+
+// NewScorer creates a scorer picking edges with the strategy, default PowerOfTwoChoices.
+func NewScorer(strategy Strategy) *Scorer {
+	if strategy == nil {
+		strategy = PowerOfTwoChoices()
+	}
+
+	return &Scorer{
+		strategy: strategy,
+		stats:    make(map[string]*Stats),
+	}
+}
+
+// Add adds an edge to the candidates.
+func (s *Scorer) Add(nodeID string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, ok := s.stats[nodeID]; !ok {
+		s.stats[nodeID] = &Stats{NodeID: nodeID}
+	}
+}
+
+// Remove removes an edge from the candidates.
+func (s *Scorer) Remove(nodeID string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	delete(s.stats, nodeID)
+}
+
+// Select picks an edge for a request except the excluded ones, the caller must report the result by Done.
+// Banned edges are skipped, if all edges are banned it returns a BannedError telling when the first ban expires,
+// so the caller can wait for it rather than hammering the edges.
+func (s *Scorer) Select(exclude ...string) (string, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, nodeID := range exclude {
+		excluded[nodeID] = true
+	}
+
+	var (
+		now        = time.Now()
+		until      time.Time
+		candidates = make([]Stats, 0, len(s.stats))
+	)
+	for _, stats := range s.stats {
+		switch {
+		case excluded[stats.NodeID]:
+		case !stats.Banned(now):
+			candidates = append(candidates, *stats)
+		case until.IsZero() || stats.BannedUntil.Before(until):
+			until = stats.BannedUntil
+		}
+	}
+
+	if len(candidates) == 0 {
+		if until.IsZero() {
+			return "", errors.Errorf("no avaliable node")
+		}
+		return "", &BannedError{Until: until}
+	}
+
+	// keep the order stable for the strategies relying on it
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].NodeID < candidates[j].NodeID
+	})
+	picked := candidates[s.strategy.Select(candidates)].NodeID
+
+	s.stats[picked].Outstanding++
+	return picked, nil
+}
+
+// Cancel releases the request to the edge selected by Select without scoring it, the request was cancelled
+// by the caller and tells nothing about the health of the edge.
+func (s *Scorer) Cancel(nodeID string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if stats, ok := s.stats[nodeID]; ok && stats.Outstanding > 0 {
+		stats.Outstanding--
+	}
+}
+
+// Done records the result of a request to the edge selected by Select.
+func (s *Scorer) Done(nodeID string, size int64, cost time.Duration, err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	stats, ok := s.stats[nodeID]
+	if !ok {
+		return
+	}
+
+	if stats.Outstanding > 0 {
+		stats.Outstanding--
+	}
+
+	stats.Requests++
+
+	if err != nil {
+		stats.Failures++
+		stats.ConsecutiveFailures++
+		stats.ErrorRate = ewma(stats.ErrorRate, 1, stats.Requests)
+
+		if stats.ConsecutiveFailures >= maxConsecutiveFailures {
+			duration := minBanDuration << stats.Bans
+			if duration > maxBanDuration || duration <= 0 {
+				duration = maxBanDuration
+			}
+			stats.Bans++
+			stats.BannedUntil = time.Now().Add(duration)
+			stats.ConsecutiveFailures = 0
+			log.Warnf("edge %s failed %d times in a row, banned for %s", nodeID, maxConsecutiveFailures, duration)
+		}
+		return
+	}
+
+	stats.ConsecutiveFailures = 0
+	stats.ErrorRate = ewma(stats.ErrorRate, 0, stats.Requests)
+	stats.Latency = time.Duration(ewma(float64(stats.Latency), float64(cost), stats.Requests-stats.Failures))
+
+	if cost > 0 {
+		speed := float64(size) / cost.Seconds()
+		stats.Throughput = ewma(stats.Throughput, speed, stats.Requests-stats.Failures)
+	}
+}
+
+// Stats returns a snapshot of the stats of all edges.
+func (s *Scorer) Stats() []Stats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	out := make([]Stats, 0, len(s.stats))
+	for _, stats := range s.stats {
+		out = append(out, *stats)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].NodeID < out[j].NodeID
+	})
+
+	return out
+}
+
+// ewma returns the exponentially weighted moving average, the first sample is taken as is.
+func ewma(prev, sample float64, samples int64) float64 {
+	if samples <= 1 {
+		return sample
+	}
+	return ewmaAlpha*sample + (1-ewmaAlpha)*prev
+}
@@ -0,0 +1,130 @@
+package selector
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// Strategy picks an edge among the candidates, implementations must be safe for concurrent use since
+// a strategy is shared by all downloads of a client.
+type Strategy interface {
+	// Select returns the index of the picked edge, candidates is never empty and sorted by node id.
+	Select(candidates []Stats) int
+}
+
+// defaultThroughput returns the mean throughput of the edges with samples, it is used to score the edges that
+// haven't served any request yet, so they get a fair chance to be picked.
+func defaultThroughput(candidates []Stats) float64 {
+	var (
+		sum   float64
+		count int
+	)
+
+	for _, c := range candidates {
+		if c.Requests > c.Failures {
+			sum += c.Throughput
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 1
+	}
+	return sum / float64(count)
+}
+
+type roundRobin struct {
+	count uint64
+}
+
+// RoundRobin picks the edges in turn regardless of their health.
+func RoundRobin() Strategy {
+	return &roundRobin{count: uint64(rand.Intn(100))}
+}
+
+func (r *roundRobin) Select(candidates []Stats) int {
+	return int(atomic.AddUint64(&r.count, 1) % uint64(len(candidates)))
+}
+
+type weightedThroughput struct{}
+
+// WeightedThroughput picks an edge randomly with a probability proportional to its throughput discounted by its error rate.
+func WeightedThroughput() Strategy {
+	return weightedThroughput{}
+}
+
+func (weightedThroughput) Select(candidates []Stats) int {
+	def := defaultThroughput(candidates)
+
+	var total float64
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		scores[i] = c.Score(def)
+		total += scores[i]
+	}
+
+	if total <= 0 {
+		return rand.Intn(len(candidates))
+	}
+
+	target := rand.Float64() * total
+	for i, score := range scores {
+		target -= score
+		if target < 0 {
+			return i
+		}
+	}
+
+	return len(candidates) - 1
+}
+
+type leastOutstanding struct{}
+
+// LeastOutstanding picks the edge with the fewest requests in flight, ties are broken by the score.
+func LeastOutstanding() Strategy {
+	return leastOutstanding{}
+}
+
+func (leastOutstanding) Select(candidates []Stats) int {
+	def := defaultThroughput(candidates)
+
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		c, b := candidates[i], candidates[best]
+		if c.Outstanding < b.Outstanding || (c.Outstanding == b.Outstanding && c.Score(def) > b.Score(def)) {
+			best = i
+		}
+	}
+
+	return best
+}
+
+type powerOfTwoChoices struct{}
+
+// PowerOfTwoChoices picks two edges at random and takes the one with the higher score per request in flight,
+// which spreads the load like LeastOutstanding while favoring the fast edges.
+func PowerOfTwoChoices() Strategy {
+	return powerOfTwoChoices{}
+}
+
+func (powerOfTwoChoices) Select(candidates []Stats) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+
+	def := defaultThroughput(candidates)
+	load := func(c Stats) float64 {
+		return c.Score(def) / float64(c.Outstanding+1)
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	if load(candidates[j]) > load(candidates[i]) {
+		return j
+	}
+	return i
+}
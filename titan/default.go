@@ -36,13 +36,13 @@ func generateTLSConfig() (*tls.Config, error) {
 	}
 
 	return &tls.Config{
-		MinVersion:         tls.VersionTLS12,
-		Certificates:       []tls.Certificate{tlsCert},
-		InsecureSkipVerify: true,
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{tlsCert},
 	}, nil
 }
 
-func defaultTLSConf() *tls.Config {
+// insecureTLSConf does not verify the certificate of the peer, for the nodes serving self-signed certificates.
+func insecureTLSConf() *tls.Config {
 	return &tls.Config{
 		MinVersion:         tls.VersionTLS12,
 		InsecureSkipVerify: true,
@@ -56,41 +56,31 @@ func defaultQUICConfig() *quic.Config {
 	}
 }
 
-func defaultHttpClient(conn net.PacketConn) *http.Client {
-	tlsConf := &tls.Config{
-		MinVersion:         tls.VersionTLS12,
-		InsecureSkipVerify: true,
-		NextProtos:         []string{http3.NextProtoH3},
-	}
-
-	conf := &quic.Config{
-		KeepAlivePeriod: time.Second,
-	}
-
-	return &http.Client{Transport: &http3.RoundTripper{
-		TLSClientConfig: tlsConf,
-		QuicConfig:      conf,
-		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
-			address, err := net.ResolveUDPAddr("udp", addr)
-			if err != nil {
-				return nil, err
-			}
-			return quic.DialEarlyContext(ctx, conn, address, "localhost", tlsCfg, cfg)
-		},
-	}}
-}
-
 func newHttpClient(conn quic.EarlyConnection, timeout time.Duration) *http.Client {
-	return &http.Client{Transport: &http3.RoundTripper{
-		TLSClientConfig: defaultTLSConf(),
-		QuicConfig:      defaultQUICConfig(),
-		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
-			return conn, nil
+	return &http.Client{Transport: &connTransport{
+		RoundTripper: &http3.RoundTripper{
+			QuicConfig: defaultQUICConfig(),
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+				return conn, nil
+			},
 		},
+		conn: conn,
 	}, Timeout: timeout}
 }
 
-func createConnection(ctx context.Context, conn net.PacketConn, remoteAddr string) (quic.EarlyConnection, error) {
+// connTransport is the transport over a connection established to an edge, closing it closes the connection.
+type connTransport struct {
+	*http3.RoundTripper
+	conn quic.EarlyConnection
+}
+
+func (t *connTransport) Close() error {
+	t.RoundTripper.Close()
+	return t.conn.CloseWithError(0, "")
+}
+
+// createConnection dials the edge at the address, the TLS configuration is the one of the edge.
+func createConnection(ctx context.Context, conn net.PacketConn, remoteAddr string, tlsConf *tls.Config) (quic.EarlyConnection, error) {
 	addr, err := net.ResolveUDPAddr("udp", remoteAddr)
 	if err != nil {
 		return nil, err
@@ -99,5 +89,5 @@ func createConnection(ctx context.Context, conn net.PacketConn, remoteAddr strin
 	ctx, cancel := context.WithTimeout(ctx, defaultTimout)
 	defer cancel()
 
-	return quic.DialEarlyContext(ctx, conn, addr, "localhost", defaultTLSConf(), defaultQUICConfig())
+	return quic.DialEarlyContext(ctx, conn, addr, remoteAddr, tlsConf, defaultQUICConfig())
 }
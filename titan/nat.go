@@ -2,11 +2,17 @@ package titan
 
 import (
 	"context"
+	"github.com/gnasnik/titan-sdk-go/internal/request"
+	"github.com/gnasnik/titan-sdk-go/internal/tracing"
 	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/ipfs/go-cid"
 	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -23,154 +29,326 @@ const (
 	minCandidatesOfDiscovery = 3
 )
 
+// errNoTraversal is returned when no NAT traversal method works for the NAT types of both sides.
+var errNoTraversal = errors.New("no NAT traversal method")
+
+// NATTest is the outcome of a single test of the NAT discovery.
+type NATTest struct {
+	Name        string
+	Description string
+	// Candidate is the candidate node sending or receiving the packet of the test
+	Candidate string
+	Err       error
+	Duration  time.Duration
+}
+
+// NATReport is the result of the NAT discovery and the outcome of every test it ran, to diagnose the connectivity of the client.
+type NATReport struct {
+	NATType    types.NATType
+	Scheduler  string
+	Candidates []string
+	// PublicAddr is the address of the client observed by the primary candidate
+	PublicAddr types.Host
+	Tests      []NATTest
+}
+
 // Discover client-side NAT type discovery
-func (s *Service) Discover() (t types.NATType, e error) {
-	defer func() {
-		s.natType = t
-		log.Debugf("My NAT type: %s", t)
-	}()
+func (s *Service) Discover(ctx context.Context) (types.NATType, error) {
+	report, err := s.DiscoverReport(ctx)
+	return report.NATType, err
+}
 
-	schedulers, err := s.GetSchedulers()
-	if err != nil {
-		return unknown, err
-	}
+// DiscoverReport discovers the NAT type like Discover, the report is returned even if the discovery failed,
+// it holds the tests run until then.
+func (s *Service) DiscoverReport(ctx context.Context) (*NATReport, error) {
+	s.nat.discovering.Lock()
+	defer s.nat.discovering.Unlock()
 
-	if len(schedulers) == 0 {
-		return unknown, errors.Errorf("can not found scheudler")
-	}
+	return s.discover(ctx)
+}
 
-	candidates, err := s.GetCandidates(schedulers[0])
-	if err != nil {
-		return unknown, err
-	}
+// discover runs the tests of the NAT discovery and records the NAT type, the caller holds the discovering lock.
+func (s *Service) discover(ctx context.Context) (report *NATReport, e error) {
+	report = &NATReport{NATType: unknown}
 
-	if len(candidates) == 0 {
-		return unknown, errors.Errorf("can not found candidates")
+	ctx, span := tracing.Start(ctx, "Service.Discover")
+	defer func() {
+		s.nat.store(report, e)
+		log.Debugf("My NAT type: %s", report.NATType)
+
+		span.SetAttributes(attribute.String("titan.nat_type", report.NATType.String()))
+		tracing.End(span, e)
+	}()
+
+	schedulerURL, candidates, err := s.candidatesOfDiscovery(ctx)
+	if err != nil {
+		return report, err
 	}
 
+	report.Scheduler = schedulerURL
+	report.Candidates = candidates
 	primaryCandidate := candidates[0]
 
-	// Test I: sends an udp packet to primary candidates
-	publicAddrPrimary, err := s.GetPublicAddress(primaryCandidate)
-	if err != nil {
-		return udpBlock, err
+	// the public address is only observed over UDP, the transport would fall back to TCP otherwise
+	udpCtx := withNetwork(ctx, "udp")
+
+	var publicAddrPrimary types.Host
+	test := runNATTest("I", "sends an udp packet to the primary candidate", primaryCandidate, func() (err error) {
+		publicAddrPrimary, err = s.GetPublicAddress(udpCtx, primaryCandidate)
+		return err
+	})
+	report.Tests = append(report.Tests, test)
+	if test.Err != nil {
+		report.NATType = udpBlock
+
+		// the candidate answering over TCP means UDP is blocked rather than the candidate is down
+		test = runNATTest("VI", "sends a tcp packet to the primary candidate", primaryCandidate, func() error {
+			_, err := s.GetPublicAddress(withNetwork(ctx, "tcp"), primaryCandidate)
+			return err
+		})
+		report.Tests = append(report.Tests, test)
+		if test.Err != nil {
+			return report, errors.Errorf("the primary candidate is reachable neither over udp: %v, nor over tcp: %v", report.Tests[0].Err, test.Err)
+		}
+
+		return report, nil
 	}
 
+	report.PublicAddr = publicAddrPrimary
 	log.Debugf("PublicAddr: %s", publicAddrPrimary)
 
 	if len(candidates) < minCandidatesOfDiscovery {
-		return unknown, errors.Errorf("insufficent candidates, want %d got %d", minCandidatesOfDiscovery, len(candidates))
+		return report, errors.Errorf("insufficent candidates, want %d got %d", minCandidatesOfDiscovery, len(candidates))
 	}
 
 	secondaryCandidate := candidates[1]
 	tertiaryCandidate := candidates[2]
 
-	// Test II: sends an udp packet to secondary candidates
-	publicAddrSecondary, err := s.GetPublicAddress(secondaryCandidate)
-	if err != nil {
-		return unknown, err
+	var publicAddrSecondary types.Host
+	test = runNATTest("II", "sends an udp packet to the secondary candidate", secondaryCandidate, func() (err error) {
+		publicAddrSecondary, err = s.GetPublicAddress(udpCtx, secondaryCandidate)
+		return err
+	})
+	report.Tests = append(report.Tests, test)
+	if test.Err != nil {
+		return report, test.Err
 	}
 
 	if publicAddrPrimary.Port != publicAddrSecondary.Port {
-		return symmetric, nil
+		report.NATType = symmetric
+		return report, nil
+	}
+
+	checks := []struct {
+		name        string
+		description string
+		candidate   string
+		network     string
+	}{
+		{"III", "the tertiary candidate sends a tcp packet to the client", tertiaryCandidate, "tcp"},
+		{"IV", "the tertiary candidate sends an udp packet to the client", tertiaryCandidate, "udp"},
+		{"V", "the primary candidate sends an udp packet to the client", primaryCandidate, "udp"},
+	}
+
+	// the tests are independent, each one records its own outcome
+	results := make([]NATTest, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+
+		go func(i int, name, description, candidate, network string) {
+			defer wg.Done()
+			results[i] = runNATTest(name, description, candidate, func() error {
+				return s.RequestCandidateToSendPackets(ctx, candidate, network, publicAddrPrimary.String())
+			})
+		}(i, check.name, check.description, check.candidate, check.network)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result.Err != nil {
+			log.Debugf("test %s failed: %v", result.Name, result.Err)
+		}
+	}
+	report.Tests = append(report.Tests, results...)
+
+	switch {
+	case results[0].Err == nil:
+		report.NATType = openInternet
+	case results[1].Err == nil:
+		report.NATType = fullCone
+	case results[2].Err == nil:
+		report.NATType = restricted
+	default:
+		report.NATType = portRestricted
+	}
+
+	return report, nil
+}
+
+// candidatesOfDiscovery returns the candidates to test the NAT with and the scheduler they belong to. The discoveries
+// are spread over the schedulers of the area, a scheduler failing or lacking candidates is skipped for the next one.
+func (s *Service) candidatesOfDiscovery(ctx context.Context) (string, []string, error) {
+	if err := s.loadSchedulers(ctx); err != nil {
+		return "", nil, err
 	}
 
 	var (
-		isOpenInternet bool
-		isFullCone     bool
-		isRestricted   bool
+		schedulerURL string
+		candidates   []string
+		lastErr      error
 	)
 
-	todos := []func() error{
-		func() error {
-			// Test III: sends a tcp packet to primaryCandidate from tertiary candidates
-			err = s.RequestCandidateToSendPackets(tertiaryCandidate, "tcp", publicAddrPrimary.String())
-			if err != nil {
-				return err
+	for _, url := range s.schedulers.ordered(true) {
+		list, err := s.GetCandidates(ctx, url)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", nil, err
 			}
 
-			isOpenInternet = true
-			return nil
-		},
-		func() error {
-			// Test IV: sends an udp packet to primaryCandidate from tertiary candidates
-			err = s.RequestCandidateToSendPackets(tertiaryCandidate, "udp", publicAddrPrimary.String())
-			if err != nil {
-				return err
+			if !request.IsRPCError(err) {
+				s.schedulers.fail(url)
 			}
 
-			isFullCone = true
-			return nil
-		},
-		func() error {
-			// Test V: sends an udp packet to primaryCandidate from primary candidates
-			err = s.RequestCandidateToSendPackets(primaryCandidate, "udp", publicAddrPrimary.String())
-			if err != nil {
-				return err
-			}
+			log.Debugf("get candidates from scheduler %s failed: %v", url, err)
+			lastErr = err
+			continue
+		}
 
-			isRestricted = true
-			return nil
-		},
-	}
+		s.schedulers.succeed(url)
+
+		if len(list) >= minCandidatesOfDiscovery {
+			return url, list, nil
+		}
 
-	var eg errgroup.Group
-	for _, todo := range todos {
-		eg.Go(todo)
+		// the first test only needs one candidate, keep the most candidates in case no scheduler has enough
+		if len(list) > len(candidates) {
+			schedulerURL, candidates = url, list
+		}
 	}
-	if err = eg.Wait(); err != nil {
-		log.Debugf("check list failed: %v", err)
+
+	if len(candidates) == 0 {
+		if lastErr != nil {
+			return "", nil, errors.Errorf("get candidates: %v", lastErr)
+		}
+		return "", nil, errors.Errorf("can not found candidates")
 	}
 
-	if isOpenInternet {
-		return openInternet, nil
-	} else if isFullCone {
-		return fullCone, nil
-	} else if isRestricted {
-		return restricted, nil
-	} else {
-		return portRestricted, nil
+	return schedulerURL, candidates, nil
+}
+
+func runNATTest(name, description, candidate string, test func() error) NATTest {
+	start := time.Now()
+	err := test()
+
+	return NATTest{
+		Name:        name,
+		Description: description,
+		Candidate:   candidate,
+		Err:         err,
+		Duration:    time.Since(start),
 	}
 }
 
-// filterAccessibleEdges filtering out the list of available edges to only include those that are accessible by the client
-// and added to the list of accessible accessibleEdges.
-func (s *Service) filterAccessibleEdges(ctx context.Context, edges []*types.Edge) error {
-	var wg sync.WaitGroup
-	for i := 0; i < len(edges); i++ {
-		if edges[i].GetNATType() == symmetric {
-			log.Warnf("A symmetric type device was found, but we haven't implemented it yet, so skip that for now.")
-			continue
-		}
+// filterAccessibleEdges filtering out the list of available edges to only include those that are accessible by the client,
+// returns the accessible edges and the http clients used to reach them, keyed by node id, and the errors of the edges
+// not accessible.
+func (s *Service) filterAccessibleEdges(ctx context.Context, edges []*types.Edge) ([]*types.Edge, map[string]*http.Client, map[string]error) {
+	var (
+		wg         sync.WaitGroup
+		lk         sync.Mutex
+		accessible []*types.Edge
+		clients    = make(map[string]*http.Client)
+		failures   = make(map[string]error)
+	)
 
+	natType := s.NATType()
+
+	for i := 0; i < len(edges); i++ {
 		wg.Add(1)
 
 		go func(edge *types.Edge) {
 			defer wg.Done()
-			client, err := s.determineEdgeClient(ctx, s.natType, edge)
-			if err != nil {
-				log.Warnf("determine edge %s(%s) http client failed: %v", edge.NodeID, edge.Address, err)
+
+			if err := s.pinEdge(ctx, edge); err != nil {
+				log.Warnf("pin edge %s(%s) failed: %v", edge.NodeID, edge.Address, err)
+
+				lk.Lock()
+				failures[edge.NodeID] = err
+				lk.Unlock()
 				return
 			}
 
-			err = s.SendPackets(client, edge.Address)
+			client, err := s.determineEdgeClient(ctx, natType, edge)
+			if err == nil {
+				err = s.SendPackets(ctx, client, edge.Address)
+			}
+
+			// the traversals failing repeatedly mean the NAT type of the client changed
+			if edgeNATType := edge.GetNATType(); edgeNATType != openInternet && edgeNATType != fullCone &&
+				ctx.Err() == nil && errors.Cause(err) != errNoTraversal {
+				s.nat.punched(err)
+			}
+
 			if err != nil {
-				log.Warnf("send packets to edge %s(%s) failed: %v", edge.NodeID, edge.Address, err)
+				log.Warnf("determine edge %s(%s) http client failed: %v", edge.NodeID, edge.Address, err)
+
+				lk.Lock()
+				failures[edge.NodeID] = err
+				lk.Unlock()
 				return
 			}
 
-			s.clk.Lock()
-			s.accessibleEdges = append(s.accessibleEdges, edge)
-			s.clients[edge.NodeID] = client
-			s.clk.Unlock()
+			lk.Lock()
+			accessible = append(accessible, edge)
+			clients[edge.NodeID] = client
+			lk.Unlock()
 		}(edges[i])
 	}
 
 	wg.Wait()
 
-	log.Debugf("got accessible edge nodes: %d", len(s.clients))
+	log.Debugf("got accessible edge nodes: %d", len(clients))
 
-	return nil
+	return accessible, clients, failures
+}
+
+// EdgeStatus is the reachability of an edge holding a file.
+type EdgeStatus struct {
+	NodeID       string
+	Address      string
+	NATType      string
+	SchedulerURL string
+	// Err is set if the edge is not accessible
+	Err error
+}
+
+// ProbeEdges returns the edges holding the file and whether the client can reach them, the edges are connected the way
+// a download does and the connections are closed once probed.
+func (s *Service) ProbeEdges(ctx context.Context, root cid.Cid) ([]EdgeStatus, error) {
+	edges, err := s.getEdgeNodesByFile(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	_, clients, failures := s.filterAccessibleEdges(ctx, edges)
+	for _, client := range clients {
+		s.closeEdgeClient(client)
+	}
+
+	status := make([]EdgeStatus, 0, len(edges))
+	for _, edge := range edges {
+		status = append(status, EdgeStatus{
+			NodeID:       edge.NodeID,
+			Address:      edge.Address,
+			NATType:      edge.NATType,
+			SchedulerURL: edge.SchedulerURL,
+			Err:          failures[edge.NodeID],
+		})
+	}
+
+	return status, nil
 }
 
 // determineEdgeClient determines that can be directly connected to using the default httpclient.
@@ -184,23 +362,39 @@ func (s *Service) determineEdgeClient(ctx context.Context, userNATType types.NAT
 		return s.httpClient, nil
 	}
 
+	// Every NAT traversal method needs UDP
+	if userNATType == udpBlock {
+		return nil, errors.Wrap(errNoTraversal, "udp is blocked")
+	}
+
+	// Check if the edge is behind a symmetric NAT, then predict the port it will use and punch through both sides.
+	// The edge address is useless even if the user side is open, since the edge uses another port to reach the user.
+	if edgeNATType == symmetric {
+		if userNATType == symmetric {
+			return nil, errors.Wrap(errNoTraversal, "both sides are symmetric NAT")
+		}
+
+		return s.traverseSymmetricNAT(ctx, edge)
+	}
+
 	// Check if the user has an open Internet NAT type, then try to establish a connection through NAT traversal
 	if userNATType == openInternet || userNATType == fullCone {
-		if err := s.EstablishConnectionFromEdge(edge); err != nil {
+		if err := s.EstablishConnectionFromEdge(ctx, edge); err != nil {
 			return nil, errors.Errorf("establish connection from edge: %v", err)
 		}
 
 		return s.httpClient, nil
 	}
 
-	// Check if the edge and the user both have a restricted cone NAT type, then request the scheduler to connect to the edge node
+	// Check if the edge and the user both have a restricted cone NAT type, then request the scheduler to connect to the edge node.
+	// A restricted cone edge only filters by ip, so it accepts whatever port a symmetric NAT of the user side allocates.
 	if edgeNATType == restricted || userNATType == restricted {
-		err := s.EstablishConnectionFromEdge(edge)
+		err := s.EstablishConnectionFromEdge(ctx, edge)
 		if err != nil {
 			return nil, errors.Errorf("request candidate to send packets: %v", err)
 		}
 
-		conn, err := createConnection(ctx, s.conn, edge.Address)
+		conn, err := createConnection(ctx, s.conn, edge.Address, s.identities.edgeTLSConfig(edge.NodeID))
 		if err != nil {
 			return nil, errors.Errorf("create connection: %v", err)
 		}
@@ -210,14 +404,14 @@ func (s *Service) determineEdgeClient(ctx context.Context, userNATType types.NAT
 
 	// Check if the edge and the user both have a restricted port cone NAT type, then try to send packets to the edge and request the scheduler to do so as well
 	if edgeNATType == portRestricted && userNATType == portRestricted {
-		go s.SendPackets(s.httpClient, edge.Address)
+		go s.SendPackets(ctx, s.httpClient, edge.Address)
 
-		err := s.EstablishConnectionFromEdge(edge)
+		err := s.EstablishConnectionFromEdge(ctx, edge)
 		if err != nil {
 			return nil, errors.Errorf("request candidate to send packets: %v", err)
 		}
 
-		conn, err := createConnection(ctx, s.conn, edge.Address)
+		conn, err := createConnection(ctx, s.conn, edge.Address, s.identities.edgeTLSConfig(edge.NodeID))
 		if err != nil {
 			return nil, errors.Errorf("create connection: %v", err)
 		}
@@ -225,10 +419,30 @@ func (s *Service) determineEdgeClient(ctx context.Context, userNATType types.NAT
 		return newHttpClient(conn, s.timeout), nil
 	}
 
-	if edgeNATType == symmetric || userNATType == symmetric {
-		// TODO: request the scheduler to send packets and guess the port
-		return nil, errors.Errorf("symmetric NAT unimplemented")
+	// A port restricted edge only lets in the packets from the exact address it punched to, which is the address
+	// its scheduler observed for the user. A symmetric NAT of the user side maps the connection to the edge to another
+	// port, and the punch request carries no address to predict, so the edge can not open the right port.
+	if userNATType == symmetric {
+		return nil, errors.Wrapf(errNoTraversal, "symmetric NAT can not traverse %s NAT", edgeNATType)
+	}
+
+	return nil, errors.Wrap(errNoTraversal, "unknown NAT type")
+}
+
+// candidateHost returns the host of the candidate, the candidate urls are rpc urls like `https://host:port/rpc/v0`.
+func candidateHost(candidate string) (string, error) {
+	if !strings.Contains(candidate, "://") {
+		candidate = "https://" + candidate
+	}
+
+	u, err := url.Parse(candidate)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Host == "" {
+		return "", errors.Errorf("empty host")
 	}
 
-	return nil, errors.Errorf("unknown NAT type")
+	return u.Host, nil
 }
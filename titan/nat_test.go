@@ -0,0 +1,73 @@
+package titan
+
+import (
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestProbeEdges(t *testing.T) {
+	tests := []struct {
+		name      string
+		clientNAT types.NATType
+		edgeNAT   string
+		// stride is the port stride of a symmetric edge
+		stride int
+		// wantErr is the cause of the edge being unreachable, nil if reachable
+		wantErr   error
+		wantPunch bool
+	}{
+		{"open edge", fullCone, "NoNAT", 0, nil, false},
+		{"restricted edge", fullCone, "RestrictedNAT", 0, nil, true},
+		{"symmetric edge by port prediction", fullCone, "SymmetricNAT", 0, nil, true},
+		{"symmetric edge of a stride in the predicted ports", fullCone, "SymmetricNAT", 7, nil, true},
+		{"symmetric edge of a stride past the predicted ports", fullCone, "SymmetricNAT", predictedPortCount + 1, errNoPredictedPort, true},
+		{"port restricted edge from restricted client", restricted, "PortRestrictedNAT", 0, nil, true},
+		{"port restricted edge from port restricted client", portRestricted, "PortRestrictedNAT", 0, nil, true},
+		{"restricted edge from symmetric client", symmetric, "RestrictedNAT", 0, nil, true},
+		{"port restricted edge from symmetric client", symmetric, "PortRestrictedNAT", 0, errNoTraversal, false},
+		{"symmetric edge from symmetric client", symmetric, "SymmetricNAT", 0, errNoTraversal, false},
+		{"open edge over tcp", udpBlock, "NoNAT", 0, nil, false},
+		{"restricted edge over tcp", udpBlock, "RestrictedNAT", 0, errNoTraversal, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// the discovery of a client behind a firewall blocking UDP waits for the QUIC handshakes to time out
+			t.Parallel()
+
+			network, root := newTestFile(t,
+				titantest.WithClientNAT(tt.clientNAT),
+				titantest.WithEdges(titantest.EdgeOptions{NATType: tt.edgeNAT, PortStride: tt.stride}),
+			)
+			s := newTestService(t, network)
+
+			// waits for the discovery in background rather than discovering again
+			s.discoverIfDue()
+			if natType := s.NATType(); natType != tt.clientNAT {
+				t.Fatalf("NAT type = %s, want %s", natType, tt.clientNAT)
+			}
+
+			status, err := s.ProbeEdges(testContext(t), root)
+			if err != nil {
+				t.Fatalf("probe edges: %v", err)
+			}
+			if len(status) != 1 {
+				t.Fatalf("edges = %d, want 1", len(status))
+			}
+
+			if tt.wantErr == nil && status[0].Err != nil {
+				t.Errorf("edge is not reachable: %v", status[0].Err)
+			}
+			if tt.wantErr != nil && errors.Cause(status[0].Err) != tt.wantErr {
+				t.Errorf("err = %v, want %v", status[0].Err, tt.wantErr)
+			}
+
+			if punched := len(network.Scheduler().Punches()) > 0; punched != tt.wantPunch {
+				t.Errorf("punched = %v, want %v", punched, tt.wantPunch)
+			}
+		})
+	}
+}
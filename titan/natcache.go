@@ -0,0 +1,307 @@
+package titan
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// assumedNATType is used until the NAT type is discovered, every traversal method works with it
+	assumedNATType = portRestricted
+
+	// natCheckInterval is how often the addresses of the host are checked for a network change
+	natCheckInterval = time.Minute
+	// natRetryInterval is the delay before discovering again after a failed discovery
+	natRetryInterval = time.Minute
+	// natDiscoveryTimeout bounds a discovery in background
+	natDiscoveryTimeout = time.Minute
+	// maxPunchFailures is the number of consecutive NAT traversal failures making the discovered NAT type suspect
+	maxPunchFailures = 3
+)
+
+// natRecord is the discovered NAT type persisted to disk.
+type natRecord struct {
+	NATType      types.NATType
+	LocalAddrs   string
+	DiscoveredAt time.Time
+}
+
+// natCache holds the NAT type of the client, it is discovered in background and again once expired or once
+// the network of the host seems to change.
+type natCache struct {
+	path string
+	ttl  time.Duration
+
+	// discovering serializes the discoveries
+	discovering sync.Mutex
+
+	lk     sync.Mutex
+	record natRecord
+	// valid is false until the NAT type is discovered, and once the network changed
+	valid         bool
+	punchFailures int
+	nextAttempt   time.Time
+	// report is the outcome of the last discovery run by the client, nil if the NAT type is loaded from the file
+	report    *NATReport
+	reportErr error
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newNATCache(path string, ttl time.Duration) *natCache {
+	c := &natCache{
+		path: path,
+		ttl:  ttl,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	if path != "" {
+		if err := c.load(); err != nil && !os.IsNotExist(err) {
+			log.Warnf("load NAT type from %s failed: %v", path, err)
+		}
+	}
+
+	return c
+}
+
+// load reads the NAT type persisted by a previous process, it is only trusted in the same network and until it expires.
+func (c *natCache) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	var record natRecord
+	if err = json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	if record.LocalAddrs != localAddrs() {
+		log.Debugf("the network changed since the NAT type was discovered, discover it again")
+		return nil
+	}
+
+	if time.Now().After(record.DiscoveredAt.Add(c.ttl)) {
+		log.Debugf("the NAT type discovered at %s expired, discover it again", record.DiscoveredAt)
+		return nil
+	}
+
+	c.record = record
+	c.valid = true
+
+	return nil
+}
+
+func (c *natCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(c.record)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// natType returns the discovered NAT type, or the assumed one if it is not discovered yet or the network changed.
+// An expired NAT type is still returned until it is discovered again.
+func (c *natCache) natType() types.NATType {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if !c.valid {
+		return assumedNATType
+	}
+	return c.record.NATType
+}
+
+// store records the result of a discovery, a failed discovery keeps the previous NAT type and is retried later.
+func (c *natCache) store(report *NATReport, err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.report = report
+	c.reportErr = err
+
+	if err != nil {
+		c.nextAttempt = time.Now().Add(natRetryInterval)
+		return
+	}
+
+	c.record = natRecord{
+		NATType:      report.NATType,
+		LocalAddrs:   localAddrs(),
+		DiscoveredAt: time.Now(),
+	}
+	c.valid = true
+	c.punchFailures = 0
+	c.nextAttempt = time.Time{}
+
+	if err = c.save(); err != nil {
+		log.Warnf("persist NAT type to %s failed: %v", c.path, err)
+	}
+}
+
+// punched records the outcome of a NAT traversal, the NAT type is discovered again after repeated failures.
+func (c *natCache) punched(err error) {
+	c.lk.Lock()
+
+	if err == nil {
+		c.punchFailures = 0
+		c.lk.Unlock()
+		return
+	}
+
+	c.punchFailures++
+	suspect := c.valid && c.punchFailures >= maxPunchFailures
+	if suspect {
+		log.Infof("%d NAT traversals failed in a row, discover the NAT type again", c.punchFailures)
+		c.valid = false
+		c.punchFailures = 0
+		c.nextAttempt = time.Time{}
+	}
+	c.lk.Unlock()
+
+	if suspect {
+		c.notify()
+	}
+}
+
+// due returns true if the NAT type should be discovered now.
+func (c *natCache) due() bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	now := time.Now()
+	if now.Before(c.nextAttempt) {
+		return false
+	}
+
+	if c.valid && c.record.LocalAddrs != localAddrs() {
+		log.Infof("the addresses of the host changed, discover the NAT type again")
+		c.valid = false
+	}
+
+	return !c.valid || now.After(c.record.DiscoveredAt.Add(c.ttl))
+}
+
+func (c *natCache) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *natCache) close() {
+	c.cancel()
+	<-c.done
+}
+
+// natLoop discovers the NAT type in background whenever it is due.
+func (s *Service) natLoop() {
+	defer close(s.nat.done)
+
+	ticker := time.NewTicker(natCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.discoverIfDue()
+
+		select {
+		case <-s.nat.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.nat.wake:
+		}
+	}
+}
+
+// discoverIfDue discovers the NAT type unless it is still valid, e.g. discovered by a call of Discover meanwhile.
+func (s *Service) discoverIfDue() {
+	s.nat.discovering.Lock()
+	defer s.nat.discovering.Unlock()
+
+	if !s.nat.due() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.nat.ctx, natDiscoveryTimeout)
+	defer cancel()
+
+	if _, err := s.discover(ctx); err != nil && s.nat.ctx.Err() == nil {
+		log.Warnf("discover NAT type failed, retry in %s: %v", natRetryInterval, err)
+	}
+}
+
+// lastReport returns the report of the last discovery, or a report holding only the NAT type loaded from the file.
+func (c *natCache) lastReport() (*NATReport, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.report == nil {
+		return &NATReport{NATType: c.record.NATType}, nil
+	}
+	return c.report, c.reportErr
+}
+
+// DiscoverIfDue returns the report of the NAT discovery like DiscoverReport, but only discovers the NAT type again
+// if it is expired or not discovered yet, a discovery in background is waited for rather than run twice.
+// The report of a NAT type loaded from the file of the cache holds no test.
+func (s *Service) DiscoverIfDue(ctx context.Context) (*NATReport, error) {
+	s.nat.discovering.Lock()
+	defer s.nat.discovering.Unlock()
+
+	if s.nat.due() {
+		return s.discover(ctx)
+	}
+	return s.nat.lastReport()
+}
+
+// NATType returns the NAT type of the client, a port restricted cone NAT is assumed until it is discovered.
+func (s *Service) NATType() types.NATType {
+	return s.nat.natType()
+}
+
+// localAddrs returns the unicast addresses of the interfaces of the host, they change when the host moves to another network.
+func localAddrs() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	var ips []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	sort.Strings(ips)
+
+	return strings.Join(ips, ",")
+}
@@ -0,0 +1,55 @@
+package titan
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNATCacheLoad(t *testing.T) {
+	const ttl = time.Hour
+
+	tests := []struct {
+		name       string
+		localAddrs string
+		age        time.Duration
+		want       bool
+	}{
+		{"fresh", localAddrs(), time.Minute, true},
+		{"expired", localAddrs(), ttl + time.Minute, false},
+		{"other network", "192.0.2.1", time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "nat.json")
+
+			data, err := json.Marshal(natRecord{
+				NATType:      symmetric,
+				LocalAddrs:   tt.localAddrs,
+				DiscoveredAt: time.Now().Add(-tt.age),
+			})
+			if err != nil {
+				t.Fatalf("marshal record: %v", err)
+			}
+			if err = os.WriteFile(path, data, 0644); err != nil {
+				t.Fatalf("write record: %v", err)
+			}
+
+			c := newNATCache(path, ttl)
+
+			want := assumedNATType
+			if tt.want {
+				want = symmetric
+			}
+			if natType := c.natType(); natType != want {
+				t.Errorf("NAT type = %s, want %s", natType, want)
+			}
+			if due := c.due(); due == tt.want {
+				t.Errorf("due = %v, want %v", due, !tt.want)
+			}
+		})
+	}
+}
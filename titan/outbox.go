@@ -0,0 +1,480 @@
+package titan
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// retryInterval is the delay before the first retry of a failed submission, it doubles on every failure
+	retryInterval = 5 * time.Second
+	// maxRetryInterval bounds the delay between two retries of a submission
+	maxRetryInterval = 10 * time.Minute
+	// closeFlushTimeout bounds the last submission of the reports when the outbox is closed
+	closeFlushTimeout = 10 * time.Second
+
+	reportSuffix = ".report"
+)
+
+// ReportStatus is the submission status of a workload report waiting in the outbox.
+type ReportStatus struct {
+	ID           string
+	SchedulerURL string
+	CreatedAt    time.Time
+	// Attempts is the number of failed submissions
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+// report is an encrypted workload report to submit to a scheduler.
+type report struct {
+	ID           string
+	SchedulerURL string
+	Data         []byte
+	CreatedAt    time.Time
+	Attempts     int
+	NextAttempt  time.Time
+	LastError    string
+
+	submitting bool
+	// attempted is closed when the submission in progress completes
+	attempted chan struct{}
+}
+
+// QueuedError is returned when the submission of a report failed after the report was queued in the outbox,
+// the report is retried in background until acknowledged.
+type QueuedError struct {
+	Err error
+}
+
+func (e *QueuedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QueuedError) Unwrap() error {
+	return e.Err
+}
+
+// Queued returns true if the error is only caused by submissions failing after their reports were queued in the outbox,
+// so the proofs of work are not lost.
+func Queued(err error) bool {
+	var queued *QueuedError
+	return errors.As(err, &queued)
+}
+
+type submitFunc func(ctx context.Context, schedulerURL string, data []byte) error
+
+// outbox holds the workload reports until the schedulers acknowledge them, the failed submissions are retried
+// with exponential backoff in background. If the directory is set, every report is persisted in it, so
+// the reports not acknowledged yet are submitted by the next outbox opening the directory.
+type outbox struct {
+	dir    string
+	submit submitFunc
+
+	lk      sync.Mutex
+	reports map[string]*report
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newOutbox(dir string, submit submitFunc) (*outbox, error) {
+	o := &outbox{
+		dir:     dir,
+		submit:  submit,
+		reports: make(map[string]*report),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	o.ctx, o.cancel = context.WithCancel(context.Background())
+
+	if dir != "" {
+		if err := o.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	go o.loop()
+
+	return o, nil
+}
+
+// load reads the reports persisted in the directory.
+func (o *outbox) load() error {
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), reportSuffix) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(o.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		var r report
+		if err = json.Unmarshal(data, &r); err != nil {
+			log.Warnf("load report %s failed, drop it: %v", entry.Name(), err)
+			os.Remove(filepath.Join(o.dir, entry.Name()))
+			continue
+		}
+
+		// retried right away by the new process
+		r.NextAttempt = time.Now()
+		o.reports[r.ID] = &r
+	}
+
+	if len(o.reports) > 0 {
+		log.Infof("%d workload reports loaded from outbox", len(o.reports))
+	}
+
+	return nil
+}
+
+// ReadReports returns the status of the workload reports persisted in the outbox directory, without submitting them
+// nor opening the outbox, so it does not take the reports over from a client using the directory.
+func ReadReports(dir string) ([]ReportStatus, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o := &outbox{reports: make(map[string]*report)}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), reportSuffix) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if os.IsNotExist(err) {
+			// acknowledged meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var r report
+		if err = json.Unmarshal(data, &r); err != nil {
+			log.Warnf("read report %s failed: %v", entry.Name(), err)
+			continue
+		}
+		o.reports[r.ID] = &r
+	}
+
+	return o.status(), nil
+}
+
+// post persists the encrypted report and submits it, the report is retried until acknowledged if the submission fails
+// and a QueuedError is returned.
+func (o *outbox) post(ctx context.Context, schedulerURL string, data []byte) error {
+	now := time.Now()
+	r := &report{
+		ID:           uuid.NewString(),
+		SchedulerURL: schedulerURL,
+		Data:         data,
+		CreatedAt:    now,
+		NextAttempt:  now,
+		submitting:   true,
+		attempted:    make(chan struct{}),
+	}
+
+	o.lk.Lock()
+	err := o.save(r)
+	if err == nil {
+		o.reports[r.ID] = r
+	}
+	o.lk.Unlock()
+
+	if err != nil {
+		return errors.Errorf("saving report failed: %v", err)
+	}
+
+	if err = o.attempt(ctx, r); err != nil {
+		return &QueuedError{Err: err}
+	}
+	return nil
+}
+
+// send submits the report unless it is already acknowledged. If the report is being submitted, it waits for
+// the submission in progress instead of submitting it twice.
+func (o *outbox) send(ctx context.Context, r *report) error {
+	o.lk.Lock()
+	if _, ok := o.reports[r.ID]; !ok {
+		o.lk.Unlock()
+		return nil
+	}
+
+	if r.submitting {
+		attempted := r.attempted
+		o.lk.Unlock()
+
+		select {
+		case <-attempted:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		o.lk.Lock()
+		defer o.lk.Unlock()
+
+		if _, ok := o.reports[r.ID]; ok {
+			return errors.Errorf("submitting report %s failed: %s", r.ID, r.LastError)
+		}
+		return nil
+	}
+	r.submitting = true
+	r.attempted = make(chan struct{})
+	o.lk.Unlock()
+
+	return o.attempt(ctx, r)
+}
+
+// attempt submits the report marked as submitting, it is removed from the outbox once acknowledged,
+// or scheduled for the next retry.
+func (o *outbox) attempt(ctx context.Context, r *report) error {
+	err := o.submit(ctx, r.SchedulerURL, r.Data)
+
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	r.submitting = false
+	close(r.attempted)
+
+	if err == nil {
+		delete(o.reports, r.ID)
+		if e := o.remove(r); e != nil {
+			log.Warnf("remove report %s failed: %v", r.ID, e)
+		}
+		return nil
+	}
+
+	r.Attempts++
+	r.LastError = err.Error()
+	r.NextAttempt = time.Now().Add(backoff(r.Attempts))
+
+	if e := o.save(r); e != nil {
+		log.Warnf("save report %s failed: %v", r.ID, e)
+	}
+
+	o.notify()
+
+	return err
+}
+
+// flush submits all the reports in the outbox right now, or waits for their submissions in progress,
+// it returns the first error.
+func (o *outbox) flush(ctx context.Context) error {
+	var eg errgroup.Group
+	for _, r := range o.all() {
+		r := r
+		eg.Go(func() error {
+			return o.send(ctx, r)
+		})
+	}
+	return eg.Wait()
+}
+
+// pending returns the reports not being submitted, which are due before the deadline if it is not zero.
+func (o *outbox) pending(deadline time.Time) []*report {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	var reports []*report
+	for _, r := range o.reports {
+		if r.submitting || (!deadline.IsZero() && r.NextAttempt.After(deadline)) {
+			continue
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// all returns all the reports in the outbox, including the ones being submitted.
+func (o *outbox) all() []*report {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	reports := make([]*report, 0, len(o.reports))
+	for _, r := range o.reports {
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// status returns the status of the reports waiting in the outbox, the oldest first.
+func (o *outbox) status() []ReportStatus {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	status := make([]ReportStatus, 0, len(o.reports))
+	for _, r := range o.reports {
+		status = append(status, ReportStatus{
+			ID:           r.ID,
+			SchedulerURL: r.SchedulerURL,
+			CreatedAt:    r.CreatedAt,
+			Attempts:     r.Attempts,
+			NextAttempt:  r.NextAttempt,
+			LastError:    r.LastError,
+		})
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].CreatedAt.Before(status[j].CreatedAt)
+	})
+
+	return status
+}
+
+// loop retries the reports whose next attempt is due.
+func (o *outbox) loop() {
+	defer close(o.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-o.wake:
+		case <-timer.C:
+			for _, r := range o.pending(time.Now()) {
+				ctx, cancel := context.WithTimeout(o.ctx, submitTimeout)
+				if err := o.send(ctx, r); err != nil {
+					log.Warnf("submit report %s failed, retry later: %v", r.ID, err)
+				}
+				cancel()
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(o.nextAttempt())
+	}
+}
+
+// nextAttempt returns the delay until the earliest retry.
+func (o *outbox) nextAttempt() time.Duration {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	delay := maxRetryInterval
+	for _, r := range o.reports {
+		if r.submitting {
+			continue
+		}
+
+		if d := time.Until(r.NextAttempt); d < delay {
+			delay = d
+		}
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay
+}
+
+// notify wakes the loop up to reschedule the retries.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close submits the reports one last time and stops retrying, the reports not acknowledged yet are kept in the directory.
+func (o *outbox) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeFlushTimeout)
+	if err := o.flush(ctx); err != nil {
+		log.Warnf("flush outbox failed: %v", err)
+	}
+	cancel()
+
+	o.cancel()
+	<-o.done
+
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	if len(o.reports) == 0 {
+		return
+	}
+
+	if o.dir == "" {
+		log.Warnf("%d workload reports are not submitted and lost", len(o.reports))
+		return
+	}
+
+	log.Infof("%d workload reports are kept in outbox %s", len(o.reports), o.dir)
+}
+
+// save writes the report to a temporary file then renames it, must be called with lk held.
+func (o *outbox) save(r *report) error {
+	if o.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(o.dir, r.ID+reportSuffix)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (o *outbox) remove(r *report) error {
+	if o.dir == "" {
+		return nil
+	}
+
+	return os.Remove(filepath.Join(o.dir, r.ID+reportSuffix))
+}
+
+func backoff(attempts int) time.Duration {
+	delay := retryInterval
+	for i := 1; i < attempts && delay < maxRetryInterval; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryInterval {
+		delay = maxRetryInterval
+	}
+
+	return delay
+}
@@ -0,0 +1,144 @@
+package titan
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+// failingSubmit returns a submit function failing the first n submissions, and the data it accepted.
+func failingSubmit(n int) (submitFunc, func() [][]byte) {
+	var (
+		lk       sync.Mutex
+		accepted [][]byte
+	)
+
+	submit := func(ctx context.Context, schedulerURL string, data []byte) error {
+		lk.Lock()
+		defer lk.Unlock()
+
+		if n > 0 {
+			n--
+			return errors.Errorf("scheduler unavailable")
+		}
+		accepted = append(accepted, data)
+		return nil
+	}
+
+	return submit, func() [][]byte {
+		lk.Lock()
+		defer lk.Unlock()
+		return accepted
+	}
+}
+
+func TestOutboxFlush(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		// flushes is the number of flushes after the report was posted
+		flushes      int
+		wantPostErr  bool
+		wantFlushErr bool
+		wantAttempts int
+		wantAccepted int
+	}{
+		{"acknowledged", 0, 0, false, false, 0, 1},
+		{"flush of nothing", 0, 1, false, false, 0, 1},
+		{"retried by flush", 1, 1, true, false, 0, 1},
+		{"still failing", 3, 2, true, true, 3, 0},
+		{"acknowledged after retries", 3, 3, true, false, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			submit, accepted := failingSubmit(tt.failures)
+
+			o, err := newOutbox("", submit)
+			if err != nil {
+				t.Fatalf("new outbox: %v", err)
+			}
+			defer o.close()
+
+			err = o.post(testContext(t), "scheduler", []byte("report"))
+			if (err != nil) != tt.wantPostErr {
+				t.Errorf("post err = %v, want error %v", err, tt.wantPostErr)
+			}
+
+			for i := 0; i < tt.flushes; i++ {
+				err = o.flush(testContext(t))
+			}
+			if tt.flushes > 0 && (err != nil) != tt.wantFlushErr {
+				t.Errorf("flush err = %v, want error %v", err, tt.wantFlushErr)
+			}
+
+			status := o.status()
+			if tt.wantAttempts == 0 && len(status) != 0 {
+				t.Errorf("pending reports = %d, want 0", len(status))
+			}
+			if tt.wantAttempts > 0 {
+				if len(status) != 1 {
+					t.Fatalf("pending reports = %d, want 1", len(status))
+				}
+				if status[0].Attempts != tt.wantAttempts || status[0].LastError == "" {
+					t.Errorf("attempts = %d, last error %q, want %d attempts", status[0].Attempts, status[0].LastError, tt.wantAttempts)
+				}
+			}
+
+			if got := len(accepted()); got != tt.wantAccepted {
+				t.Errorf("accepted reports = %d, want %d", got, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestOutboxPersist(t *testing.T) {
+	dir := t.TempDir()
+
+	submit, _ := failingSubmit(2)
+	o, err := newOutbox(dir, submit)
+	if err != nil {
+		t.Fatalf("new outbox: %v", err)
+	}
+
+	if err = o.post(testContext(t), "scheduler", []byte("report")); err == nil {
+		t.Fatalf("post succeeded, want error")
+	}
+
+	// the report is submitted once more by close, and kept in the directory since it fails again
+	o.close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read outbox: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("reports in outbox = %d, want 1", len(entries))
+	}
+
+	submit, accepted := failingSubmit(0)
+	o, err = newOutbox(dir, submit)
+	if err != nil {
+		t.Fatalf("reopen outbox: %v", err)
+	}
+	defer o.close()
+
+	status := o.status()
+	if len(status) != 1 || status[0].Attempts != 2 {
+		t.Fatalf("reloaded reports = %+v, want 1 report of 2 attempts", status)
+	}
+
+	if err = o.flush(testContext(t)); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if got := accepted(); len(got) != 1 || string(got[0]) != "report" {
+		t.Errorf("accepted reports = %q, want the report", got)
+	}
+
+	if entries, _ = os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("reports in outbox after flush = %d, want 0", len(entries))
+	}
+}
@@ -0,0 +1,318 @@
+package titan
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gnasnik/titan-sdk-go/internal/request"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// schedulerRefreshInterval is how often the access point is loaded again and its schedulers probed
+	schedulerRefreshInterval = 10 * time.Minute
+	// schedulerDownInterval is how long a scheduler failing a call is only tried after the others
+	schedulerDownInterval = 30 * time.Second
+	// schedulerProbeTimeout bounds the probe of the latency of a scheduler
+	schedulerProbeTimeout = 3 * time.Second
+	// schedulerRefreshTimeout bounds the refresh of the schedulers in background
+	schedulerRefreshTimeout = 30 * time.Second
+)
+
+// SchedulerStatus is the state of a scheduler of the access point of the client.
+type SchedulerStatus struct {
+	URL string
+	// Latency is the round trip time of the last probe, zero if the scheduler did not answer it
+	Latency time.Duration
+	// DownUntil is set when the scheduler failed a call, it is only tried after the others until then
+	DownUntil time.Time
+}
+
+// schedulerPool holds the schedulers of the access point of the client, ordered by their latency. The calls which
+// any scheduler can answer fail over to the next scheduler on errors.
+type schedulerPool struct {
+	// loading serializes the loads of the access point, the pool is still readable meanwhile
+	loading sync.Mutex
+
+	lk     sync.Mutex
+	areaID string
+	list   []*SchedulerStatus
+	loaded time.Time
+	// next is the index the round robin of the NAT discovery starts from
+	next int
+}
+
+// stale returns true if the access point should be loaded again.
+func (p *schedulerPool) stale() bool {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	return len(p.list) == 0 || time.Since(p.loaded) > schedulerRefreshInterval
+}
+
+func (p *schedulerPool) set(areaID string, list []*SchedulerStatus) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.areaID = areaID
+	p.list = list
+	p.loaded = time.Now()
+	p.next = 0
+}
+
+// retryLater keeps the current schedulers and loads the access point again once the down interval elapsed.
+func (p *schedulerPool) retryLater() {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.loaded = time.Now().Add(schedulerDownInterval - schedulerRefreshInterval)
+}
+
+// ordered returns the urls of the schedulers to try in order, the available ones by latency and then the ones
+// failed recently. If balance is set, the available ones are rotated on each call to spread the load.
+func (p *schedulerPool) ordered(balance bool) []string {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	now := time.Now()
+
+	var available, down []string
+	for _, status := range p.list {
+		if status.DownUntil.After(now) {
+			down = append(down, status.URL)
+		} else {
+			available = append(available, status.URL)
+		}
+	}
+
+	if balance && len(available) > 0 {
+		start := p.next % len(available)
+		p.next++
+		available = append(available[start:], available[:start]...)
+	}
+
+	return append(available, down...)
+}
+
+// fail marks the scheduler down, the scheduler answering an error is still up.
+func (p *schedulerPool) fail(url string) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	for _, status := range p.list {
+		if status.URL == url {
+			status.DownUntil = time.Now().Add(schedulerDownInterval)
+		}
+	}
+}
+
+func (p *schedulerPool) succeed(url string) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	for _, status := range p.list {
+		if status.URL == url {
+			status.DownUntil = time.Time{}
+		}
+	}
+}
+
+func (p *schedulerPool) status() (string, []SchedulerStatus) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	out := make([]SchedulerStatus, 0, len(p.list))
+	for _, status := range p.list {
+		out = append(out, *status)
+	}
+
+	return p.areaID, out
+}
+
+// GetAccessPoint get the area and the schedulers serving the client from the locator.
+func (s *Service) GetAccessPoint(ctx context.Context) (*types.AccessPoint, error) {
+	serializedParams, err := json.Marshal(params{""})
+	if err != nil {
+		return nil, errors.Errorf("marshaling params failed: %v", err)
+	}
+
+	req := request.Request{
+		Jsonrpc: "2.0",
+		ID:      "1",
+		Method:  "titan.GetUserAccessPoint",
+		Params:  serializedParams,
+	}
+
+	header := http.Header{}
+	if s.token != "" {
+		header.Add("Authorization", "Bearer "+s.token)
+	}
+	data, err := request.PostJsonRPC(ctx, s.httpClient, s.baseAPI, req, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var out types.AccessPoint
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, errors.Errorf("unmarshal access point: %v", err)
+	}
+
+	return &out, nil
+}
+
+// GetSchedulers get scheduler list in the same region, ordered by their latency. The list is cached and refreshed
+// periodically, the schedulers failing calls are moved to the end of it.
+func (s *Service) GetSchedulers(ctx context.Context) ([]string, error) {
+	if err := s.loadSchedulers(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.schedulers.ordered(false), nil
+}
+
+// Schedulers returns the area of the client and the state of its schedulers, by latency.
+func (s *Service) Schedulers(ctx context.Context) (string, []SchedulerStatus, error) {
+	if err := s.loadSchedulers(ctx); err != nil {
+		return "", nil, err
+	}
+
+	areaID, status := s.schedulers.status()
+	return areaID, status, nil
+}
+
+// loadSchedulers loads the access point and probes the latency of its schedulers if the pool is stale. Once loaded,
+// the pool is refreshed in background and the calls go on with the schedulers loaded before.
+func (s *Service) loadSchedulers(ctx context.Context) error {
+	if !s.schedulers.stale() {
+		return nil
+	}
+
+	if _, list := s.schedulers.status(); len(list) > 0 {
+		if s.schedulers.loading.TryLock() {
+			go func() {
+				defer s.schedulers.loading.Unlock()
+
+				ctx, cancel := context.WithTimeout(context.Background(), schedulerRefreshTimeout)
+				defer cancel()
+
+				if err := s.refreshSchedulers(ctx); err != nil {
+					log.Warnf("refresh schedulers failed, keep the schedulers loaded before: %v", err)
+					s.schedulers.retryLater()
+				}
+			}()
+		}
+		return nil
+	}
+
+	s.schedulers.loading.Lock()
+	defer s.schedulers.loading.Unlock()
+
+	if !s.schedulers.stale() {
+		return nil
+	}
+
+	return s.refreshSchedulers(ctx)
+}
+
+// refreshSchedulers loads the access point and orders its schedulers by the latency of a probe.
+func (s *Service) refreshSchedulers(ctx context.Context) error {
+	ap, err := s.GetAccessPoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(ap.SchedulerURLs) == 0 {
+		return errors.Errorf("can not find scheduler")
+	}
+
+	list := make([]*SchedulerStatus, len(ap.SchedulerURLs))
+
+	var wg sync.WaitGroup
+	for i, url := range ap.SchedulerURLs {
+		wg.Add(1)
+
+		go func(i int, url string) {
+			defer wg.Done()
+
+			list[i] = &SchedulerStatus{URL: url}
+			latency, err := s.probeScheduler(ctx, url)
+			if err != nil {
+				log.Debugf("probe scheduler %s failed: %v", url, err)
+				list[i].DownUntil = time.Now().Add(schedulerDownInterval)
+				return
+			}
+			list[i].Latency = latency
+		}(i, url)
+	}
+	wg.Wait()
+
+	// the schedulers not answering the probe go last, in the order of the access point
+	sort.SliceStable(list, func(i, j int) bool {
+		if (list[i].Latency == 0) != (list[j].Latency == 0) {
+			return list[j].Latency == 0
+		}
+		return list[i].Latency < list[j].Latency
+	})
+
+	s.schedulers.set(ap.AreaID, list)
+	log.Debugf("schedulers of area %s: %v", ap.AreaID, s.schedulers.ordered(false))
+
+	return nil
+}
+
+// probeScheduler returns the round trip time of a call to the scheduler.
+func (s *Service) probeScheduler(ctx context.Context, url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, schedulerProbeTimeout)
+	defer cancel()
+
+	req := request.Request{
+		Jsonrpc: "2.0",
+		ID:      "1",
+		Method:  "titan.Version",
+		Params:  nil,
+	}
+
+	start := time.Now()
+	// an error answered by the scheduler still measures the round trip
+	if _, err := request.PostJsonRPC(ctx, s.httpClient, url, req, nil); err != nil && !request.IsRPCError(err) {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
+// callScheduler sends the JSON-RPC request to the schedulers by their latency until one succeeds, for the calls
+// any scheduler of the area can answer. It returns the result and the url of the scheduler answering it.
+func (s *Service) callScheduler(ctx context.Context, req request.Request, header http.Header) ([]byte, string, error) {
+	urls, err := s.GetSchedulers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var lastErr error
+	for _, url := range urls {
+		data, err := request.PostJsonRPC(ctx, s.httpClient, url, req, header)
+		if err == nil {
+			s.schedulers.succeed(url)
+			return data, url, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, "", err
+		}
+
+		// an error answered by the scheduler means it is up, another one may still succeed
+		if !request.IsRPCError(err) {
+			s.schedulers.fail(url)
+		}
+
+		log.Debugf("call %s on scheduler %s failed: %v", req.Method, url, err)
+		lastErr = err
+	}
+
+	return nil, "", errors.Wrapf(lastErr, "%s failed on all %d schedulers", req.Method, len(urls))
+}
@@ -0,0 +1,84 @@
+package titan
+
+import (
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallSchedulerFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		// down is the number of the schedulers going down, by latency
+		down    int
+		wantErr bool
+	}{
+		{"all up", 0, false},
+		{"fastest down", 1, false},
+		{"all down", 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, err := titantest.NewNetwork(titantest.WithSchedulers(2))
+			if err != nil {
+				t.Fatalf("new network: %v", err)
+			}
+			defer network.Close()
+
+			s := newTestService(t, network)
+
+			_, status, err := s.Schedulers(testContext(t))
+			if err != nil {
+				t.Fatalf("load schedulers: %v", err)
+			}
+			if len(status) != 2 {
+				t.Fatalf("schedulers = %d, want 2", len(status))
+			}
+
+			schedulers := make(map[string]*titantest.Scheduler)
+			for _, scheduler := range network.Schedulers() {
+				schedulers[scheduler.URL()] = scheduler
+			}
+
+			var down []string
+			for _, scheduler := range status[:tt.down] {
+				schedulers[scheduler.URL].SetUnavailable(true)
+				down = append(down, scheduler.URL)
+			}
+
+			candidates, err := s.GetCandidates(testContext(t), "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("get candidates: err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// the error of the last scheduler is kept as the cause
+				if cause := errors.Cause(err); cause == err || strings.Contains(cause.Error(), "failed on all") {
+					t.Errorf("cause of %v = %v, want the error of the last scheduler", err, cause)
+				}
+			} else if len(candidates) != len(network.Candidates()) {
+				t.Errorf("candidates = %d, want %d", len(candidates), len(network.Candidates()))
+			}
+
+			_, status, err = s.Schedulers(testContext(t))
+			if err != nil {
+				t.Fatalf("schedulers: %v", err)
+			}
+
+			var gotDown []string
+			for _, scheduler := range status {
+				if time.Now().Before(scheduler.DownUntil) {
+					gotDown = append(gotDown, scheduler.URL)
+				}
+			}
+			sort.Strings(gotDown)
+			sort.Strings(down)
+			if !equalStrings(gotDown, down) {
+				t.Errorf("schedulers down = %v, want %v", gotDown, down)
+			}
+		})
+	}
+}
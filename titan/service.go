@@ -5,20 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/gnasnik/titan-sdk-go/cache"
 	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/event"
 	"github.com/gnasnik/titan-sdk-go/internal/codec"
 	"github.com/gnasnik/titan-sdk-go/internal/crypto"
 	"github.com/gnasnik/titan-sdk-go/internal/request"
+	"github.com/gnasnik/titan-sdk-go/selector"
 	"github.com/gnasnik/titan-sdk-go/types"
 	"github.com/gorilla/mux"
-	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/sync/errgroup"
 	"io"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -44,24 +45,25 @@ type Service struct {
 	httpClient *http.Client
 	timeout    time.Duration
 
-	conn            net.PacketConn
-	natType         types.NATType
-	accessibleEdges []*types.Edge
-
-	count   int
-	started bool
-
-	clk     sync.Mutex
-	clients map[string]*http.Client // holds the connection between user side and edge node
-
-	plk    sync.Mutex
-	proofs map[string]*proofParam
-}
-
-type proofParam struct {
-	Proofs       *types.WorkloadReport
-	SchedulerKey string
-	SchedulerURL string
+	conn      net.PacketConn
+	nat       *natCache
+	cache     cache.Cache
+	strategy  selector.Strategy
+	observer  event.Observer
+	h3Server  *http3.Server
+	tcpServer *http.Server
+	outbox    *outbox
+
+	schedulers schedulerPool
+	identities *identities
+
+	slk      sync.Mutex
+	sessions map[*Session]struct{}
+	closed   bool
+	// submissions counts the proofs of work being submitted, the outbox is closed once they are all posted
+	submissions sync.WaitGroup
+	// drained is set once Close started waiting for the submissions, no submission can start after it
+	drained bool
 }
 
 type params []interface{}
@@ -71,113 +73,164 @@ func New(options config.Config) (*Service, error) {
 		return nil, errors.Errorf("address or Token is empty")
 	}
 
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
 	conn, err := net.ListenPacket("udp4", options.ListenAddr)
 	if err != nil {
 		return nil, err
 	}
 
+	ids := newIdentities(options.TLSPolicy, options.RootCAs)
+	nat := newNATCache(options.NATCachePath, options.NATTTL)
+
+	tlsConf, err := generateTLSConfig()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Errorf("generate TLS config: %v", err)
+	}
+
+	handler := newHandler()
+
 	s := &Service{
 		baseAPI:    getRpcV0URL(options.Address),
+		nat:        nat,
 		token:      options.Token,
-		httpClient: defaultHttpClient(conn),
+		httpClient: &http.Client{Transport: newTransport(conn, ids, nat)},
+		identities: ids,
 		timeout:    options.Timeout,
-		count:      rand.Intn(100),
 		conn:       conn,
-		started:    false,
-		clients:    make(map[string]*http.Client),
-		proofs:     make(map[string]*proofParam),
+		cache:      options.Cache,
+		strategy:   options.Strategy,
+		observer:   options.Observer,
+		h3Server:   &http3.Server{TLSConfig: tlsConf.Clone(), Handler: handler},
+		tcpServer:  &http.Server{TLSConfig: tlsConf.Clone(), Handler: handler, ReadHeaderTimeout: 30 * time.Second},
+		sessions:   make(map[*Session]struct{}),
+	}
+
+	if s.outbox, err = newOutbox(options.OutboxDir, s.SubmitProofOfWork); err != nil {
+		conn.Close()
+		return nil, errors.Errorf("open outbox: %v", err)
+	}
+
+	go s.h3Server.Serve(conn)
+
+	// the candidates check the connectivity over TCP on the same port, and the client is still reachable over TCP
+	// when UDP is blocked
+	if ln, err := net.Listen("tcp", conn.LocalAddr().String()); err != nil {
+		log.Warnf("tcp listen failed: %v", err)
+	} else {
+		go serverTCP(s.tcpServer, ln)
 	}
 
-	go serverHTTP(conn)
-	go serverTCP(conn)
+	go s.natLoop()
 
 	return s, nil
 }
 
-func getRpcV0URL(baseURL string) string {
-	return fmt.Sprintf("%s/rpc/v0", baseURL)
-}
+// Close submits the proofs of work of the sessions not closed yet, then stops the servers and closes the connections
+// of the service. The service can not be used anymore once closed.
+func (s *Service) Close() error {
+	s.slk.Lock()
+	if s.closed {
+		s.slk.Unlock()
+		return nil
+	}
+	s.closed = true
 
-func serverHTTP(conn net.PacketConn) {
-	handler := mux.NewRouter()
-	handler.HandleFunc("/ping", func(writer http.ResponseWriter, h *http.Request) {
-		writer.Write([]byte("pong"))
-	})
+	sessions := make([]*Session, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.slk.Unlock()
 
-	tlsConf, err := generateTLSConfig()
+	var eg errgroup.Group
+	for _, session := range sessions {
+		session := session
+		eg.Go(session.Close)
+	}
+
+	err := eg.Wait()
 	if err != nil {
-		log.Errorf("http3 server create TLS configure failed: %v", err)
+		log.Errorf("submit proofs of work failed: %v", err)
 	}
 
-	(&http3.Server{
-		TLSConfig: tlsConf,
-		Handler:   handler,
-	}).Serve(conn)
+	// the sessions closed by their readers at the same time may still be submitting
+	s.slk.Lock()
+	s.drained = true
+	s.slk.Unlock()
+	s.submissions.Wait()
 
-}
+	s.outbox.close()
+	s.nat.close()
 
-func serverTCP(conn net.PacketConn) {
-	srv := &http.Server{
-		ReadHeaderTimeout: 30 * time.Second,
+	if e := s.h3Server.Close(); e != nil {
+		log.Debugf("close http3 server: %v", e)
 	}
 
-	log.Debugf("listen tcp on: %s", conn.LocalAddr().String())
-	ln, le := net.Listen("tcp", conn.LocalAddr().String())
-	if le != nil {
-		log.Errorf("tcp listen failed: %v", le)
+	if e := s.tcpServer.Close(); e != nil {
+		log.Debugf("close tcp server: %v", e)
 	}
-	srv.Serve(ln)
-}
 
-// GetBlock retrieves a raw block from titan http gateway
-func (s *Service) GetBlock(ctx context.Context, cid cid.Cid) (blocks.Block, error) {
-	err := s.loadEdges(ctx, cid)
-	if err != nil {
-		return nil, err
+	if t, ok := s.httpClient.Transport.(*transport); ok {
+		t.Close()
 	}
 
-	edge, client, err := s.selectEdge()
-	if err != nil {
-		return nil, err
+	if e := s.conn.Close(); e != nil && err == nil {
+		err = e
 	}
 
-	start := time.Now()
-	namespace := fmt.Sprintf("ipfs/%s", cid.String())
-	size, data, err := getData(client, edge, namespace, formatRaw, nil)
-	if err != nil {
-		return nil, errors.Errorf("post request failed: %v", err)
-	}
+	return err
+}
+
+// beginSubmit registers a submission of proofs of work, it returns false if the service is closed already.
+// The caller must call s.submissions.Done once the submission completes.
+func (s *Service) beginSubmit() bool {
+	s.slk.Lock()
+	defer s.slk.Unlock()
 
-	proofs := &proofOfWorkParams{
-		cid:    cid,
-		tStart: start,
-		tEnd:   time.Now(),
-		size:   size,
-		edge:   edge,
+	if s.drained {
+		return false
 	}
+	s.submissions.Add(1)
+	return true
+}
 
-	if err = s.generateProofOfWork(proofs); err != nil {
-		return nil, errors.Errorf("generate proof of work failed: %v", err)
+// closeEdgeClient closes the connection of the http client created to reach an edge.
+func (s *Service) closeEdgeClient(client *http.Client) {
+	// the clients of open edges share the service client, which is closed with the service
+	if client == s.httpClient {
+		return
 	}
 
-	return blocks.NewBlock(data), nil
+	if closer, ok := client.Transport.(io.Closer); ok {
+		closer.Close()
+	}
 }
 
-func (s *Service) selectEdge() (*types.Edge, *http.Client, error) {
-	if len(s.accessibleEdges) == 0 {
-		return nil, nil, errors.Errorf("no avaliable node")
-	}
+func getRpcV0URL(baseURL string) string {
+	return fmt.Sprintf("%s/rpc/v0", baseURL)
+}
 
-	luckyEdge := s.roundRobin()
+// newHandler returns the handler of the servers of the client, the candidates ping it to check the connectivity.
+func newHandler() http.Handler {
+	handler := mux.NewRouter()
+	handler.HandleFunc("/ping", func(writer http.ResponseWriter, h *http.Request) {
+		writer.Write([]byte("pong"))
+	})
 
-	s.clk.Lock()
-	client := s.clients[luckyEdge.NodeID]
-	s.clk.Unlock()
+	return handler
+}
 
-	return luckyEdge, client, nil
+func serverTCP(srv *http.Server, ln net.Listener) {
+	log.Debugf("listen tcp on: %s", ln.Addr().String())
+	if err := srv.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+		log.Errorf("tcp server failed: %v", err)
+	}
 }
 
-func getData(client *http.Client, edge *types.Edge, namespace string, format string, requestHeader http.Header) (int64, []byte, error) {
+func getData(ctx context.Context, client *http.Client, edge *types.Edge, namespace string, format string, requestHeader http.Header) (int64, []byte, error) {
 	body, err := codec.Encode(edge.Token)
 	if err != nil {
 		return 0, nil, errors.Errorf("send request: %v", err)
@@ -185,7 +238,7 @@ func getData(client *http.Client, edge *types.Edge, namespace string, format str
 
 	resp, err := request.NewBuilder(client, edge.Address, namespace, requestHeader).
 		Option("format", format).
-		BodyBytes(body).Get(context.Background())
+		BodyBytes(body).Get(ctx)
 	if err != nil {
 		return 0, nil, errors.Errorf("send request: %v", err)
 	}
@@ -222,123 +275,7 @@ func getFileSizeFromContentRange(contentRange string) (int64, error) {
 	return strconv.ParseInt(subs[1], 10, 64)
 }
 
-// loadEdges retrieves all accessible edge nodes of a file
-func (s *Service) loadEdges(ctx context.Context, cid cid.Cid) error {
-	if s.started {
-		return nil
-	}
-
-	s.started = true
-
-	edges, err := s.getEdgeNodesByFile(cid)
-	if err != nil {
-		return err
-	}
-
-	if len(edges) == 0 {
-		return errors.Errorf("no edge node found for cid: %s", cid.String())
-	}
-
-	return s.filterAccessibleEdges(ctx, edges)
-}
-
-// GetRange retrieves specific byte ranges of UnixFS files and raw blocks.
-func (s *Service) GetRange(ctx context.Context, cid cid.Cid, start, end int64) (int64, []byte, error) {
-	err := s.loadEdges(ctx, cid)
-	if err != nil {
-		return 0, nil, err
-	}
-
-	edge, client, err := s.selectEdge()
-	if err != nil {
-		return 0, nil, err
-	}
-
-	startTime := time.Now()
-	namespace := fmt.Sprintf("ipfs/%s", cid.String())
-	header := http.Header{}
-	header.Add("Range", fmt.Sprintf("bytes=%d-%d", start, end))
-
-	log.Debugf("pull data from: %s", edge.Address)
-	size, data, err := getData(client, edge, namespace, formatCAR, header)
-	if err != nil {
-		return 0, nil, errors.Errorf("post request failed: %v", err)
-	}
-
-	proofs := &proofOfWorkParams{
-		cid:    cid,
-		tStart: startTime,
-		tEnd:   time.Now(),
-		size:   size,
-		edge:   edge,
-		rStart: start,
-		rEnd:   end,
-	}
-
-	if err = s.generateProofOfWork(proofs); err != nil {
-		return 0, nil, errors.Errorf("generate proof of work failed: %v", err)
-	}
-
-	return size, data, nil
-}
-
-func (s *Service) EdgeSize() int {
-	return len(s.accessibleEdges)
-}
-
-type proofOfWorkParams struct {
-	cid    cid.Cid
-	tStart time.Time
-	tEnd   time.Time
-	size   int64
-	edge   *types.Edge
-	rStart int64
-	rEnd   int64
-}
-
-// generateProofOfWork generates proofs of work for per request.
-func (s *Service) generateProofOfWork(params *proofOfWorkParams) error {
-	cost := params.tEnd.Sub(params.tStart)
-	speed := params.size / int64(cost)
-	url := params.edge.SchedulerURL
-	key := params.edge.SchedulerKey
-
-	newProof := &proofParam{
-		Proofs: &types.WorkloadReport{
-			TokenID: params.edge.Token.ID,
-			NodeID:  params.edge.NodeID,
-			Workload: &types.Workload{
-				StartTime:     params.tStart.Unix(),
-				EndTime:       params.tEnd.Unix(),
-				DownloadSpeed: speed,
-				DownloadSize:  params.size,
-			},
-		},
-		SchedulerURL: url,
-		SchedulerKey: key,
-	}
-
-	s.plk.Lock()
-
-	prev, ok := s.proofs[params.edge.Token.ID]
-	if ok {
-		prevWorkload := prev.Proofs.Workload
-		newWorkload := newProof.Proofs.Workload
-		newProof.Proofs.Workload = &types.Workload{
-			StartTime:     prevWorkload.StartTime,
-			EndTime:       newWorkload.EndTime,
-			DownloadSpeed: (prevWorkload.DownloadSpeed + newWorkload.DownloadSpeed) / 2,
-			DownloadSize:  prevWorkload.DownloadSize + newWorkload.DownloadSize,
-		}
-	}
-
-	s.proofs[params.edge.Token.ID] = newProof
-	s.plk.Unlock()
-
-	return nil
-}
-
-func (s *Service) getEdgeNodesByFile(cid cid.Cid) ([]*types.Edge, error) {
+func (s *Service) getEdgeNodesByFile(ctx context.Context, cid cid.Cid) ([]*types.Edge, error) {
 	serializedParams, err := json.Marshal(params{cid.String()})
 	if err != nil {
 		return nil, errors.Errorf("marshaling params failed: %v", err)
@@ -355,7 +292,7 @@ func (s *Service) getEdgeNodesByFile(cid cid.Cid) ([]*types.Edge, error) {
 	if s.token != "" {
 		header.Add("Authorization", "Bearer "+s.token)
 	}
-	data, err := request.PostJsonRPC(s.httpClient, s.baseAPI, req, header)
+	data, err := request.PostJsonRPC(ctx, s.httpClient, s.baseAPI, req, header)
 	if err != nil {
 		return nil, errors.Errorf("post jsonrpc failed: %v", err)
 	}
@@ -377,6 +314,7 @@ func (s *Service) getEdgeNodesByFile(cid cid.Cid) ([]*types.Edge, error) {
 				SchedulerKey: item.SchedulerKey,
 			}
 			log.Debugf("edge node id: %s, ip: %s, NAT: %s", e.NodeID, e.Address, e.NATType)
+			s.identities.addEdge(e)
 			out = append(out, e)
 		}
 	}
@@ -384,37 +322,8 @@ func (s *Service) getEdgeNodesByFile(cid cid.Cid) ([]*types.Edge, error) {
 	return out, err
 }
 
-// GetSchedulers get scheduler list in the same region
-func (s *Service) GetSchedulers() ([]string, error) {
-	serializedParams, err := json.Marshal(params{""})
-	if err != nil {
-		return nil, errors.Errorf("marshaling params failed: %v", err)
-	}
-
-	req := request.Request{
-		Jsonrpc: "2.0",
-		ID:      "1",
-		Method:  "titan.GetUserAccessPoint",
-		Params:  serializedParams,
-	}
-
-	header := http.Header{}
-	if s.token != "" {
-		header.Add("Authorization", "Bearer "+s.token)
-	}
-	data, err := request.PostJsonRPC(s.httpClient, s.baseAPI, req, header)
-	if err != nil {
-		return nil, err
-	}
-
-	var out types.AccessPoint
-	err = json.Unmarshal(data, &out)
-
-	return out.SchedulerURLs, nil
-}
-
-// GetCandidates get candidates list in the same region
-func (s *Service) GetCandidates(schedulerURL string) ([]string, error) {
+// GetCandidates get candidates list in the same region, from any scheduler of the area if schedulerURL is empty
+func (s *Service) GetCandidates(ctx context.Context, schedulerURL string) ([]string, error) {
 	req := request.Request{
 		Jsonrpc: "2.0",
 		ID:      "1",
@@ -422,19 +331,32 @@ func (s *Service) GetCandidates(schedulerURL string) ([]string, error) {
 		Params:  nil,
 	}
 
-	data, err := request.PostJsonRPC(s.httpClient, schedulerURL, req, nil)
+	var (
+		data []byte
+		err  error
+	)
+	if schedulerURL == "" {
+		data, _, err = s.callScheduler(ctx, req, nil)
+	} else {
+		data, err = request.PostJsonRPC(ctx, s.httpClient, schedulerURL, req, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	var out []string
-	err = json.Unmarshal(data, &out)
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, errors.Errorf("unmarshal candidates: %v", err)
+	}
+
+	// the candidates serve self-signed certificates
+	s.identities.addNodes(out...)
 
 	return out, nil
 }
 
 // GetPublicAddress return the public address
-func (s *Service) GetPublicAddress(schedulerURL string) (types.Host, error) {
+func (s *Service) GetPublicAddress(ctx context.Context, schedulerURL string) (types.Host, error) {
 	serializedParams, err := json.Marshal(params{})
 	if err != nil {
 		return types.Host{}, errors.Errorf("marshaling params failed: %v", err)
@@ -447,7 +369,7 @@ func (s *Service) GetPublicAddress(schedulerURL string) (types.Host, error) {
 		Params:  serializedParams,
 	}
 
-	data, err := request.PostJsonRPC(s.httpClient, schedulerURL, req, nil)
+	data, err := request.PostJsonRPC(ctx, s.httpClient, schedulerURL, req, nil)
 	if err != nil {
 		return types.Host{}, err
 	}
@@ -464,7 +386,7 @@ func (s *Service) GetPublicAddress(schedulerURL string) (types.Host, error) {
 }
 
 // RequestCandidateToSendPackets sends packet from server side to determine the application connectivity
-func (s *Service) RequestCandidateToSendPackets(remoteAddr string, network, url string) error {
+func (s *Service) RequestCandidateToSendPackets(ctx context.Context, remoteAddr string, network, url string) error {
 	reqURL := fmt.Sprintf("https://%s/ping", url)
 	serializedParams, err := json.Marshal(params{
 		network, reqURL,
@@ -480,7 +402,7 @@ func (s *Service) RequestCandidateToSendPackets(remoteAddr string, network, url
 		Params:  serializedParams,
 	}
 
-	_, err = request.PostJsonRPC(s.httpClient, remoteAddr, req, nil)
+	_, err = request.PostJsonRPC(ctx, s.httpClient, remoteAddr, req, nil)
 	if err != nil {
 		return errors.Errorf("request candidate to send packets failed: %v", err)
 	}
@@ -489,7 +411,7 @@ func (s *Service) RequestCandidateToSendPackets(remoteAddr string, network, url
 }
 
 // EstablishConnectionFromEdge creates a connection from edge node side for the application though the scheduler
-func (s *Service) EstablishConnectionFromEdge(edge *types.Edge) error {
+func (s *Service) EstablishConnectionFromEdge(ctx context.Context, edge *types.Edge) error {
 	serializedParams, err := json.Marshal(params{edge.ToNatPunchReq()})
 	if err != nil {
 		return errors.Errorf("marshaling params failed: %v", err)
@@ -502,7 +424,7 @@ func (s *Service) EstablishConnectionFromEdge(edge *types.Edge) error {
 		Params:  serializedParams,
 	}
 
-	_, err = request.PostJsonRPC(s.httpClient, edge.SchedulerURL, req, nil)
+	_, err = request.PostJsonRPC(ctx, s.httpClient, edge.SchedulerURL, req, nil)
 	if err != nil {
 		return errors.Errorf("establish connection from edge failed: %v", err)
 	}
@@ -511,7 +433,7 @@ func (s *Service) EstablishConnectionFromEdge(edge *types.Edge) error {
 }
 
 // SendPackets sends packet to the edge node
-func (s *Service) SendPackets(client *http.Client, remoteAddr string) error {
+func (s *Service) SendPackets(ctx context.Context, client *http.Client, remoteAddr string) error {
 	req := request.Request{
 		Jsonrpc: "2.0",
 		ID:      "1",
@@ -520,7 +442,7 @@ func (s *Service) SendPackets(client *http.Client, remoteAddr string) error {
 	}
 
 	rpcURL := getRpcV0URL(remoteAddr)
-	_, err := request.PostJsonRPC(client, rpcURL, req, nil)
+	_, err := request.PostJsonRPC(ctx, client, rpcURL, req, nil)
 	if err != nil {
 		return errors.Errorf("send packet failed: %v", err)
 	}
@@ -529,13 +451,13 @@ func (s *Service) SendPackets(client *http.Client, remoteAddr string) error {
 }
 
 // SubmitProofOfWork submits a proof of work for a downloaded file
-func (s *Service) SubmitProofOfWork(schedulerAddr string, data []byte) error {
+func (s *Service) SubmitProofOfWork(ctx context.Context, schedulerAddr string, data []byte) error {
 	pushURL, err := getPushURL(schedulerAddr)
 	if err != nil {
 		return err
 	}
 
-	streamReader, err := pushStream(s.httpClient, pushURL, bytes.NewReader(data))
+	streamReader, pushed, err := pushStream(ctx, s.httpClient, pushURL, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -552,14 +474,36 @@ func (s *Service) SubmitProofOfWork(schedulerAddr string, data []byte) error {
 		Params:  serializedParams,
 	}
 
-	_, err = request.PostJsonRPC(s.httpClient, schedulerAddr, req, nil)
+	_, err = request.PostJsonRPC(ctx, s.httpClient, schedulerAddr, req, nil)
 	if err != nil {
+		// the scheduler fails the submission if the push failed, which is the cause worth reporting
+		select {
+		case e := <-pushed:
+			if e != nil {
+				return errors.Errorf("pushing proof of work failed: %v", e)
+			}
+		default:
+		}
 		return errors.Errorf("submitting proof of work failed: %v", err)
 	}
 
+	if err = <-pushed; err != nil {
+		return errors.Errorf("pushing proof of work failed: %v", err)
+	}
+
 	return nil
 }
 
+// PendingReports returns the workload reports which are not acknowledged by the schedulers yet.
+func (s *Service) PendingReports() []ReportStatus {
+	return s.outbox.status()
+}
+
+// FlushReports submits the pending workload reports right now instead of waiting for the next retry.
+func (s *Service) FlushReports(ctx context.Context) error {
+	return s.outbox.flush(ctx)
+}
+
 func getPushURL(addr string) (string, error) {
 	pushURL, err := url.Parse(addr)
 	if err != nil {
@@ -577,57 +521,6 @@ func getPushURL(addr string) (string, error) {
 	return pushURL.String(), nil
 }
 
-// roundRobin is a round-robin strategy algorithm for node selection.
-func (s *Service) roundRobin() *types.Edge {
-	s.clk.Lock()
-	defer s.clk.Unlock()
-
-	s.count++
-	return s.accessibleEdges[s.count%len(s.accessibleEdges)]
-}
-
-func (s *Service) cleanup() {
-	s.started = false
-	s.accessibleEdges = nil
-	s.clients = make(map[string]*http.Client)
-	s.proofs = make(map[string]*proofParam)
-}
-
-func (s *Service) EndOfFile() error {
-	defer s.cleanup()
-
-	s.plk.Lock()
-	keyInScheduler := make(map[string]string)
-	schedulerGroup := make(map[string][]*types.WorkloadReport)
-	for _, param := range s.proofs {
-		_, ok := schedulerGroup[param.SchedulerURL]
-		if !ok {
-			schedulerGroup[param.SchedulerURL] = make([]*types.WorkloadReport, 0)
-		}
-		keyInScheduler[param.SchedulerURL] = param.SchedulerKey
-		schedulerGroup[param.SchedulerURL] = append(schedulerGroup[param.SchedulerURL], param.Proofs)
-	}
-	s.plk.Unlock()
-
-	var eg errgroup.Group
-	for url, paramList := range schedulerGroup {
-		if len(paramList) == 0 {
-			continue
-		}
-
-		eg.Go(func() error {
-			key := keyInScheduler[url]
-			data, err := encrypt(key, paramList)
-			if err != nil {
-				return errors.Errorf("encrypting proof failed: %v", err)
-			}
-
-			return s.SubmitProofOfWork(url, data)
-		})
-	}
-	return eg.Wait()
-}
-
 func encrypt(key string, value interface{}) ([]byte, error) {
 	data, err := codec.Encode(value)
 	if err != nil {
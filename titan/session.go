@@ -0,0 +1,640 @@
+package titan
+
+import (
+	"context"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/gnasnik/titan-sdk-go/internal/tracing"
+	"github.com/gnasnik/titan-sdk-go/selector"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/gnasnik/titan-sdk-go/workload"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// submitTimeout bounds the submission of the proofs of work of a session
+const submitTimeout = 30 * time.Second
+
+// Session holds the state of a single download: the accessible edges of the file, the http clients
+// created by NAT traversal and the accumulated proofs of work. Sessions do not share any state with each
+// other, so one Service can run many downloads concurrently.
+type Session struct {
+	service   *Service
+	root      cid.Cid
+	created   time.Time
+	observers []event.Observer
+	finished  sync.Once
+
+	// llk serializes the loading of the edges, loaded is set once they are loaded or failed to for good
+	llk     sync.Mutex
+	loaded  bool
+	loadErr error
+
+	scorer *selector.Scorer
+
+	clk     sync.Mutex
+	edges   map[string]*types.Edge
+	clients map[string]*http.Client // holds the connection between user side and edge node
+
+	plk      sync.Mutex
+	proofs   map[string]*ProofParam
+	received int64
+}
+
+// ProofParam is a proof of work accumulated in a session and the scheduler it will be submitted to.
+type ProofParam struct {
+	Proofs *types.WorkloadReport
+	// Stats is the accounting of the transfers the proof is generated from, it is nil in the proofs saved by
+	// older versions, then the workload of the proof is taken as a single transfer.
+	Stats        *workload.Stats
+	SchedulerKey string
+	SchedulerURL string
+}
+
+// report generates the workload report from the accounting.
+func (p *ProofParam) report() *types.WorkloadReport {
+	return &types.WorkloadReport{
+		TokenID:  p.Stats.TokenID,
+		NodeID:   p.Stats.NodeID,
+		Workload: p.Stats.Workload(),
+	}
+}
+
+// NewSession creates a download session for the file identified by root, the session must be closed
+// once the download is finished. The events of the session are emitted to the observer of the service
+// and the given observers.
+func (s *Service) NewSession(root cid.Cid, observers ...event.Observer) *Session {
+	session := &Session{
+		service: s,
+		root:    root,
+		created: time.Now(),
+		scorer:  selector.NewScorer(s.strategy),
+		edges:   make(map[string]*types.Edge),
+		clients: make(map[string]*http.Client),
+		proofs:  make(map[string]*ProofParam),
+	}
+
+	for _, observer := range append([]event.Observer{s.observer}, observers...) {
+		if observer != nil {
+			session.observers = append(session.observers, observer)
+		}
+	}
+
+	s.slk.Lock()
+	s.sessions[session] = struct{}{}
+	s.slk.Unlock()
+
+	return session
+}
+
+// Root returns the cid of the file being downloaded in the session.
+func (s *Session) Root() cid.Cid {
+	return s.root
+}
+
+// Emit sends the event of the download to the observers of the session.
+func (s *Session) Emit(e event.Event) {
+	if len(s.observers) == 0 {
+		return
+	}
+
+	e.Root = s.root
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for _, observer := range s.observers {
+		observer(e)
+	}
+}
+
+// loadEdges retrieves all accessible edge nodes of the root file, only the first call completing takes effect.
+// A call whose context is done before the edges are loaded leaves the session as is, so the next call loads them again.
+func (s *Session) loadEdges(ctx context.Context) error {
+	s.llk.Lock()
+	defer s.llk.Unlock()
+
+	if s.loaded {
+		return s.loadErr
+	}
+
+	err := s.load(ctx)
+	if ctx.Err() != nil {
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+
+	s.loaded, s.loadErr = true, err
+	return err
+}
+
+func (s *Session) load(ctx context.Context) error {
+	edges, err := s.service.getEdgeNodesByFile(ctx, s.root)
+	if err != nil {
+		return err
+	}
+
+	if len(edges) == 0 {
+		s.Emit(event.Event{Type: event.EdgesDiscovered, Edges: len(edges)})
+		return errors.Errorf("no edge node found for cid: %s", s.root.String())
+	}
+
+	accessible, clients, failures := s.service.filterAccessibleEdges(ctx, edges)
+
+	// the edges the traversals were cancelled for are not inaccessible, they are tried again by the next call
+	if ctx.Err() != nil {
+		for _, client := range clients {
+			s.service.closeEdgeClient(client)
+		}
+		return ctx.Err()
+	}
+
+	s.Emit(event.Event{Type: event.EdgesDiscovered, Edges: len(edges)})
+	for _, edge := range edges {
+		s.Emit(event.Event{
+			Type:    event.EdgeConnected,
+			NodeID:  edge.NodeID,
+			NATType: edge.NATType,
+			Err:     failures[edge.NodeID],
+		})
+	}
+
+	s.clk.Lock()
+	for _, edge := range accessible {
+		s.edges[edge.NodeID] = edge
+		s.scorer.Add(edge.NodeID)
+	}
+	s.clients = clients
+	s.clk.Unlock()
+
+	return nil
+}
+
+// GetBlock retrieves a raw block from titan http gateway, the data is hashed and checked against the cid.
+// An edge returning forged data is removed from the session, and an edge the request fails on is skipped for the block,
+// either way the block is requested from another edge until every edge was tried.
+func (s *Session) GetBlock(ctx context.Context, cid cid.Cid) (blocks.Block, error) {
+	ctx, span := tracing.Start(ctx, "Session.GetBlock", attribute.String("titan.cid", cid.String()))
+	block, err := s.getBlock(ctx, cid)
+	tracing.End(span, err)
+	return block, err
+}
+
+func (s *Session) getBlock(ctx context.Context, cid cid.Cid) (blocks.Block, error) {
+	if block, ok := s.getCachedBlock(ctx, cid); ok {
+		return block, nil
+	}
+
+	err := s.loadEdges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		// failed are the edges the request failed on, the block is requested from the other edges
+		failed  []string
+		lastErr error
+	)
+
+	for {
+		edge, client, err := s.selectEdge(failed...)
+		if err != nil {
+			if lastErr != nil {
+				return nil, errors.Errorf("post request failed: %v", lastErr)
+			}
+			return nil, err
+		}
+
+		tracing.SetAttributes(ctx, attribute.String("titan.node_id", edge.NodeID))
+
+		start := time.Now()
+		namespace := fmt.Sprintf("ipfs/%s", cid.String())
+		_, data, err := getData(ctx, client, edge, namespace, formatRaw, nil)
+		if err != nil {
+			s.Emit(event.Event{Type: event.BlockFailed, Cid: cid, NodeID: edge.NodeID, Duration: time.Since(start), Err: err})
+
+			if ctx.Err() != nil {
+				s.scorer.Cancel(edge.NodeID)
+				return nil, errors.Errorf("post request failed: %v", err)
+			}
+			s.scorer.Done(edge.NodeID, 0, time.Since(start), err)
+
+			failed = append(failed, edge.NodeID)
+			lastErr = err
+			continue
+		}
+
+		// the edge is only scored once the block is verified, a forged block is a failure of the edge
+		block, err := verifyBlock(cid, data)
+		s.scorer.Done(edge.NodeID, int64(len(data)), time.Since(start), err)
+		if err != nil {
+			log.Warnf("edge %s(%s) returned an invalid block: %v", edge.NodeID, edge.Address, err)
+			s.Emit(event.Event{Type: event.BlockFailed, Cid: cid, NodeID: edge.NodeID, Duration: time.Since(start), Err: err})
+			s.removeEdge(edge)
+			continue
+		}
+
+		s.cacheBlock(ctx, block)
+
+		transfer := workload.Transfer{
+			Cid:      cid,
+			Start:    start,
+			Duration: time.Since(start),
+			Bytes:    int64(len(data)),
+		}
+		s.recordTransfer(edge, transfer)
+
+		s.Emit(event.Event{
+			Type:     event.BlockReceived,
+			Cid:      cid,
+			NodeID:   edge.NodeID,
+			Bytes:    transfer.Bytes,
+			Duration: transfer.Duration,
+		})
+
+		return block, nil
+	}
+}
+
+// verifyBlock hashes the data with the multihash of the cid and makes sure it matches.
+func verifyBlock(c cid.Cid, data []byte) (blocks.Block, error) {
+	hashed, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hashed.Equals(c) {
+		return nil, errors.Errorf("mismatch in content integrity, expected: %s, got: %s", c, hashed)
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// GetRange retrieves specific byte ranges of UnixFS files and raw blocks.
+func (s *Session) GetRange(ctx context.Context, cid cid.Cid, start, end int64) (int64, []byte, error) {
+	ctx, span := tracing.Start(ctx, "Session.GetRange",
+		attribute.String("titan.cid", cid.String()),
+		attribute.Int64("titan.range.start", start),
+		attribute.Int64("titan.range.end", end),
+	)
+	size, data, err := s.getRange(ctx, cid, start, end)
+	span.SetAttributes(attribute.Int("titan.bytes", len(data)))
+	tracing.End(span, err)
+	return size, data, err
+}
+
+func (s *Session) getRange(ctx context.Context, cid cid.Cid, start, end int64) (int64, []byte, error) {
+	if s.service.cache != nil {
+		if size, data, ok := s.service.cache.GetRange(ctx, cid, start, end); ok {
+			return size, data, nil
+		}
+	}
+
+	err := s.loadEdges(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	edge, client, err := s.selectEdge()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tracing.SetAttributes(ctx, attribute.String("titan.node_id", edge.NodeID))
+
+	startTime := time.Now()
+	namespace := fmt.Sprintf("ipfs/%s", cid.String())
+	header := http.Header{}
+	header.Add("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	log.Debugf("pull data from: %s", edge.Address)
+	s.Emit(event.Event{Type: event.RangeStarted, Cid: cid, NodeID: edge.NodeID, Start: start, End: end})
+
+	size, data, err := getData(ctx, client, edge, namespace, formatCAR, header)
+	if err != nil && ctx.Err() != nil {
+		s.scorer.Cancel(edge.NodeID)
+	} else {
+		s.scorer.Done(edge.NodeID, int64(len(data)), time.Since(startTime), err)
+	}
+	if err != nil {
+		s.Emit(event.Event{
+			Type:     event.RangeFailed,
+			Cid:      cid,
+			NodeID:   edge.NodeID,
+			Start:    start,
+			End:      end,
+			Duration: time.Since(startTime),
+			Err:      err,
+		})
+		return 0, nil, errors.Errorf("post request failed: %v", err)
+	}
+
+	// the size is the size of the whole file, only the data of the range is served
+	transfer := workload.Transfer{
+		Cid:        cid,
+		Start:      startTime,
+		Duration:   time.Since(startTime),
+		Bytes:      int64(len(data)),
+		RangeStart: start,
+		RangeEnd:   end,
+	}
+	s.recordTransfer(edge, transfer)
+
+	s.Emit(event.Event{
+		Type:     event.RangeCompleted,
+		Cid:      cid,
+		NodeID:   edge.NodeID,
+		Start:    start,
+		End:      end,
+		Bytes:    transfer.Bytes,
+		Duration: transfer.Duration,
+	})
+
+	return size, data, nil
+}
+
+// CacheRange caches the data of the range [start, end] of the file of the size, once the caller verified it.
+// The ranges retrieved by GetRange are not cached, since the bytes of a range of a CAR file can not be verified alone.
+func (s *Session) CacheRange(ctx context.Context, cid cid.Cid, start, end, size int64, data []byte) {
+	if s.service.cache == nil {
+		return
+	}
+
+	if err := s.service.cache.PutRange(ctx, cid, start, end, size, data); err != nil {
+		log.Warnf("cache range failed: %v", err)
+	}
+}
+
+func (s *Session) getCachedBlock(ctx context.Context, cid cid.Cid) (blocks.Block, bool) {
+	if s.service.cache == nil {
+		return nil, false
+	}
+
+	block, err := s.service.cache.Blockstore().Get(ctx, cid)
+	if err != nil {
+		return nil, false
+	}
+
+	return block, true
+}
+
+func (s *Session) cacheBlock(ctx context.Context, block blocks.Block) {
+	if s.service.cache == nil {
+		return
+	}
+
+	if err := s.service.cache.Blockstore().Put(ctx, block); err != nil {
+		log.Warnf("cache block failed: %v", err)
+	}
+}
+
+// selectEdge picks an edge by the scorer except the excluded ones, the caller must report the result of the request
+// to the scorer.
+func (s *Session) selectEdge(exclude ...string) (*types.Edge, *http.Client, error) {
+	nodeID, err := s.scorer.Select(exclude...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.clk.Lock()
+	defer s.clk.Unlock()
+
+	edge, ok := s.edges[nodeID]
+	if !ok {
+		return nil, nil, errors.Errorf("edge %s was removed", nodeID)
+	}
+
+	return edge, s.clients[nodeID], nil
+}
+
+// removeEdge removes a misbehaving edge from the session, so it won't be selected again.
+func (s *Session) removeEdge(edge *types.Edge) {
+	s.scorer.Remove(edge.NodeID)
+
+	s.clk.Lock()
+	defer s.clk.Unlock()
+
+	delete(s.edges, edge.NodeID)
+	delete(s.clients, edge.NodeID)
+}
+
+// EdgeStats returns the health of the edges observed in the session.
+func (s *Session) EdgeStats() []selector.Stats {
+	return s.scorer.Stats()
+}
+
+// EdgeSize returns the number of accessible edges in the session.
+func (s *Session) EdgeSize() int {
+	s.clk.Lock()
+	defer s.clk.Unlock()
+
+	return len(s.edges)
+}
+
+// recordTransfer accounts the transfer served by the edge into the workload of its token.
+func (s *Session) recordTransfer(edge *types.Edge, t workload.Transfer) {
+	t.NodeID = edge.NodeID
+	t.TokenID = edge.Token.ID
+
+	s.plk.Lock()
+	defer s.plk.Unlock()
+
+	s.received += t.Bytes
+
+	proof, ok := s.proofs[t.TokenID]
+	if !ok {
+		proof = &ProofParam{
+			Stats:        workload.NewStats(t.TokenID, t.NodeID),
+			SchedulerKey: edge.SchedulerKey,
+			SchedulerURL: edge.SchedulerURL,
+		}
+		s.proofs[t.TokenID] = proof
+	}
+
+	proof.Stats.Add(t)
+}
+
+// addProof merges the proof into the workload of the same token, the caller must hold plk.
+func (s *Session) addProof(newProof *ProofParam) {
+	stats := newProof.Stats
+	if stats == nil {
+		stats = workload.FromWorkload(newProof.Proofs)
+	} else {
+		stats = stats.Clone()
+	}
+
+	if prev, ok := s.proofs[stats.TokenID]; ok {
+		prev.Stats.Merge(stats)
+		return
+	}
+
+	s.proofs[stats.TokenID] = &ProofParam{
+		Stats:        stats,
+		SchedulerKey: newProof.SchedulerKey,
+		SchedulerURL: newProof.SchedulerURL,
+	}
+}
+
+// Proofs returns a snapshot of the proofs of work accumulated in the session.
+func (s *Session) Proofs() []*ProofParam {
+	s.plk.Lock()
+	defer s.plk.Unlock()
+
+	out := make([]*ProofParam, 0, len(s.proofs))
+	for _, proof := range s.proofs {
+		out = append(out, &ProofParam{
+			Proofs:       proof.report(),
+			Stats:        proof.Stats.Clone(),
+			SchedulerKey: proof.SchedulerKey,
+			SchedulerURL: proof.SchedulerURL,
+		})
+	}
+
+	return out
+}
+
+// Workload returns a snapshot of the accounting of the transfers in the session, one per edge.
+func (s *Session) Workload() []*workload.Stats {
+	s.plk.Lock()
+	defer s.plk.Unlock()
+
+	out := make([]*workload.Stats, 0, len(s.proofs))
+	for _, proof := range s.proofs {
+		out = append(out, proof.Stats.Clone())
+	}
+
+	return out
+}
+
+// AddProofs merges proofs of work into the session, e.g. the proofs restored from an interrupted download,
+// so they are submitted together with the proofs of the session.
+func (s *Session) AddProofs(proofs []*ProofParam) {
+	s.plk.Lock()
+	defer s.plk.Unlock()
+
+	for _, proof := range proofs {
+		if proof == nil || (proof.Stats == nil && (proof.Proofs == nil || proof.Proofs.Workload == nil)) {
+			continue
+		}
+		s.addProof(proof)
+	}
+}
+
+// EndOfFile submits the proofs of work accumulated in the session to the schedulers.
+// The proofs are taken out of the session, so calling it more than once does not submit twice.
+// The encrypted reports are kept in the outbox of the service and retried until acknowledged if the submission fails,
+// the error of the first attempt is returned and Queued reports true for it.
+func (s *Session) EndOfFile() error {
+	s.plk.Lock()
+	proofs := s.proofs
+	s.proofs = make(map[string]*ProofParam)
+	s.plk.Unlock()
+
+	if len(proofs) == 0 {
+		return nil
+	}
+
+	if !s.service.beginSubmit() {
+		return errors.Errorf("service closed, %d proofs of work are not submitted", len(proofs))
+	}
+	defer s.service.submissions.Done()
+
+	keyInScheduler := make(map[string]string)
+	schedulerGroup := make(map[string][]*types.WorkloadReport)
+	for _, param := range proofs {
+		keyInScheduler[param.SchedulerURL] = param.SchedulerKey
+		schedulerGroup[param.SchedulerURL] = append(schedulerGroup[param.SchedulerURL], param.report())
+	}
+
+	// the proofs are submitted even if the download was cancelled, so they do not share the context of it
+	ctx, cancel := context.WithTimeout(context.Background(), submitTimeout)
+	defer cancel()
+
+	var (
+		eg errgroup.Group
+		lk sync.Mutex
+		// lost is the first error of the proofs which are not queued in the outbox, it is returned over the others
+		lost error
+	)
+	for url, paramList := range schedulerGroup {
+		if len(paramList) == 0 {
+			continue
+		}
+
+		url, paramList := url, paramList
+		eg.Go(func() error {
+			key := keyInScheduler[url]
+			data, err := encrypt(key, paramList)
+			if err == nil {
+				err = s.service.outbox.post(ctx, url, data)
+				s.Emit(event.Event{Type: event.ProofSubmitted, SchedulerURL: url, Err: err})
+			} else {
+				err = errors.Errorf("encrypting proof failed: %v", err)
+			}
+
+			if err != nil && !Queued(err) {
+				lk.Lock()
+				if lost == nil {
+					lost = err
+				}
+				lk.Unlock()
+			}
+
+			return err
+		})
+	}
+
+	err := eg.Wait()
+	if lost != nil {
+		return lost
+	}
+	return err
+}
+
+// Close submits the remaining proofs of work, closes the connections created for the session and detaches it
+// from the service. It can be called more than once.
+func (s *Session) Close() error {
+	return s.CloseWithError(nil)
+}
+
+// CloseWithError closes the session like Close, the download is reported finished with the error.
+func (s *Session) CloseWithError(cause error) error {
+	err := s.EndOfFile()
+	s.Release(cause)
+	return err
+}
+
+// Release closes the connections created for the session and detaches it from the service without submitting
+// the proofs of work, the caller takes them over, e.g. to resume an interrupted download. The download is reported
+// finished with the error.
+func (s *Session) Release(cause error) {
+	s.finished.Do(func() {
+		s.plk.Lock()
+		received := s.received
+		s.plk.Unlock()
+
+		s.Emit(event.Event{Type: event.Finished, Bytes: received, Duration: time.Since(s.created), Err: cause})
+	})
+
+	s.service.slk.Lock()
+	delete(s.service.sessions, s)
+	s.service.slk.Unlock()
+
+	s.clk.Lock()
+	clients := s.clients
+	s.clients = make(map[string]*http.Client)
+	s.edges = make(map[string]*types.Edge)
+	s.clk.Unlock()
+
+	for _, client := range clients {
+		s.service.closeEdgeClient(client)
+	}
+}
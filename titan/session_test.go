@@ -0,0 +1,218 @@
+package titan
+
+import (
+	"context"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/event"
+	"github.com/gnasnik/titan-sdk-go/selector"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"github.com/ipfs/go-cid"
+	"testing"
+	"time"
+)
+
+func newTestService(t *testing.T, network *titantest.Network, opts ...config.Option) *Service {
+	t.Helper()
+
+	s, err := New(network.Config(opts...))
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// newTestFile starts a network of the edges holding a file of random data.
+func newTestFile(t *testing.T, opts ...titantest.Option) (*titantest.Network, cid.Cid) {
+	t.Helper()
+
+	network, err := titantest.NewNetwork(opts...)
+	if err != nil {
+		t.Fatalf("new network: %v", err)
+	}
+	t.Cleanup(func() { network.Close() })
+
+	root, _, err := network.AddRandomFile(64<<10, 4<<10)
+	if err != nil {
+		t.Fatalf("add file: %v", err)
+	}
+
+	return network, root
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// banned returns the node ids of the edges banned in the session.
+func banned(session *Session) []string {
+	var nodeIDs []string
+	for _, stats := range session.EdgeStats() {
+		if stats.Banned(time.Now()) {
+			nodeIDs = append(nodeIDs, stats.NodeID)
+		}
+	}
+	return nodeIDs
+}
+
+func TestGetBlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []titantest.EdgeOptions
+		// wantEdges are the edges left in the session
+		wantEdges  int
+		wantBanned []string
+		wantErr    bool
+	}{
+		{"corrupt edge removed", []titantest.EdgeOptions{{Corrupt: true}, {}}, 1, nil, false},
+		{"failing edge banned", []titantest.EdgeOptions{{Fail: true}, {}}, 2, []string{"e_0"}, false},
+		{"all edges corrupt", []titantest.EdgeOptions{{Corrupt: true}, {Corrupt: true}}, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, root := newTestFile(t, titantest.WithEdges(tt.edges...))
+			s := newTestService(t, network, config.EdgeSelectStrategyOption(selector.RoundRobin()))
+
+			session := s.NewSession(root)
+			defer session.Close()
+
+			// without a cache, the block is requested from the edges every time
+			var err error
+			for i := 0; i < 10 && err == nil; i++ {
+				_, err = session.GetBlock(testContext(t), root)
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+
+			if size := session.EdgeSize(); size != tt.wantEdges {
+				t.Errorf("edges = %d, want %d", size, tt.wantEdges)
+			}
+
+			if got := banned(session); !equalStrings(got, tt.wantBanned) {
+				t.Errorf("banned edges = %v, want %v", got, tt.wantBanned)
+			}
+		})
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		edges         []titantest.EdgeOptions
+		wantErrors    int
+		wantBanned    []string
+		wantAllBanned bool
+	}{
+		{"healthy edges", []titantest.EdgeOptions{{}, {}}, 0, nil, false},
+		{"failing edge banned", []titantest.EdgeOptions{{Fail: true}, {}}, 3, []string{"e_0"}, false},
+		{"all edges banned", []titantest.EdgeOptions{{Fail: true}, {Fail: true}}, 10, []string{"e_0", "e_1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, root := newTestFile(t, titantest.WithEdges(tt.edges...))
+			s := newTestService(t, network, config.EdgeSelectStrategyOption(selector.RoundRobin()))
+
+			session := s.NewSession(root)
+			defer session.Close()
+
+			var (
+				errs    int
+				lastErr error
+			)
+			for i := 0; i < 10; i++ {
+				if _, _, err := session.GetRange(testContext(t), root, 0, 1023); err != nil {
+					errs++
+					lastErr = err
+				}
+			}
+
+			if errs != tt.wantErrors {
+				t.Errorf("errors = %d, want %d, last error: %v", errs, tt.wantErrors, lastErr)
+			}
+
+			if got := banned(session); !equalStrings(got, tt.wantBanned) {
+				t.Errorf("banned edges = %v, want %v", got, tt.wantBanned)
+			}
+
+			if _, allBanned := selector.BannedUntil(lastErr); allBanned != tt.wantAllBanned {
+				t.Errorf("all edges banned = %v, want %v, last error: %v", allBanned, tt.wantAllBanned, lastErr)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadEdgesRetry(t *testing.T) {
+	network, root := newTestFile(t)
+	s := newTestService(t, network)
+
+	session := s.NewSession(root)
+	defer session.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := session.GetBlock(cancelled, root); err == nil {
+		t.Fatalf("get block with a cancelled context succeeded")
+	}
+
+	// the edges are loaded again with a live context
+	if _, err := session.GetBlock(testContext(t), root); err != nil {
+		t.Fatalf("get block: %v", err)
+	}
+
+	if size := session.EdgeSize(); size != len(network.Edges()) {
+		t.Errorf("edges = %d, want %d", size, len(network.Edges()))
+	}
+}
+
+func TestCancelledRequestsNotScored(t *testing.T) {
+	network, root := newTestFile(t, titantest.WithEdges(titantest.EdgeOptions{}))
+	s := newTestService(t, network)
+
+	var cancel context.CancelFunc
+	session := s.NewSession(root, func(e event.Event) {
+		if e.Type == event.RangeStarted {
+			cancel()
+		}
+	})
+	defer session.Close()
+
+	for i := 0; i < 5; i++ {
+		ctx, stop := context.WithCancel(context.Background())
+		cancel = stop
+
+		_, _, err := session.GetRange(ctx, root, 0, 1023)
+		stop()
+		if err == nil {
+			t.Fatalf("get range cancelled when started succeeded")
+		}
+	}
+
+	if got := banned(session); len(got) != 0 {
+		t.Errorf("banned edges = %v, want none", got)
+	}
+	for _, stats := range session.EdgeStats() {
+		if stats.Failures != 0 || stats.Outstanding != 0 {
+			t.Errorf("edge %s: failures = %d, outstanding = %d, want 0", stats.NodeID, stats.Failures, stats.Outstanding)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package titan
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"golang.org/x/xerrors"
 	"io"
@@ -24,76 +25,75 @@ type ReaderStream struct {
 	Info string
 }
 
-func pushStream(client *http.Client, pushURL string, r io.Reader) (ReaderStream, error) {
+// pushStream sends the data of a reader param to the push url in background, the returned channel receives the result
+// of the push once it is done.
+func pushStream(ctx context.Context, client *http.Client, pushURL string, r io.Reader) (ReaderStream, <-chan error, error) {
 	reqID := uuid.New()
 	u, err := url.Parse(pushURL)
 	if err != nil {
-		return ReaderStream{}, xerrors.Errorf("parsing push address: %w", err)
+		return ReaderStream{}, nil, xerrors.Errorf("parsing push address: %w", err)
 	}
 	u.Path = path.Join(u.Path, reqID.String())
 
+	pushed := make(chan error, 1)
 	go func() {
-		// TODO: figure out errors here
-		for {
-			req, err := http.NewRequest("HEAD", u.String(), nil)
-			if err != nil {
-				log.Errorf("sending HEAD request for the reder param: %+v", err)
-				return
-			}
-			req.Header.Set("Content-Type", "application/octet-stream")
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Errorf("sending reader param: %+v", err)
-				return
-			}
-			// todo do we need to close the body for a head request?
-
-			if resp.StatusCode == http.StatusFound {
-				nextStr := resp.Header.Get("Location")
-				u, err = url.Parse(nextStr)
-				if err != nil {
-					log.Errorf("sending HEAD request for the reder param, parsing next url (%s): %+v", nextStr, err)
-					return
-				}
-
-				continue
-			}
-
-			if resp.StatusCode == http.StatusNoContent { // reader closed before reading anything
-				// todo just return??
-				return
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				b, _ := io.ReadAll(resp.Body)
-				log.Errorf("sending reader param (%s): non-200 status: %s, msg: '%s'", u.String(), resp.Status, string(b))
-				return
-			}
+		pushed <- push(ctx, client, u, r)
+	}()
 
-			break
-		}
+	return ReaderStream{Type: PushStream, Info: reqID.String()}, pushed, nil
+}
 
-		// now actually send the data
-		req, err := http.NewRequest("POST", u.String(), r)
+func push(ctx context.Context, client *http.Client, u *url.URL, r io.Reader) error {
+	for {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", u.String(), nil)
 		if err != nil {
-			log.Errorf("sending reader param: %+v", err)
-			return
+			return xerrors.Errorf("sending HEAD request for the reader param: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/octet-stream")
 		resp, err := client.Do(req)
 		if err != nil {
-			log.Errorf("sending reader param: %+v", err)
-			return
+			return xerrors.Errorf("sending reader param: %w", err)
 		}
+		resp.Body.Close()
 
-		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusFound {
+			nextStr := resp.Header.Get("Location")
+			u, err = url.Parse(nextStr)
+			if err != nil {
+				return xerrors.Errorf("sending HEAD request for the reader param, parsing next url (%s): %w", nextStr, err)
+			}
+
+			continue
+		}
+
+		if resp.StatusCode == http.StatusNoContent { // reader closed before reading anything
+			return nil
+		}
 
 		if resp.StatusCode != http.StatusOK {
-			b, _ := io.ReadAll(resp.Body)
-			log.Errorf("sending reader param (%s): non-200 status: %s, msg: '%s'", u.String(), resp.Status, string(b))
-			return
+			return xerrors.Errorf("sending HEAD request for the reader param (%s): non-200 status: %s", u.String(), resp.Status)
 		}
-	}()
 
-	return ReaderStream{Type: PushStream, Info: reqID.String()}, nil
+		break
+	}
+
+	// now actually send the data
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), r)
+	if err != nil {
+		return xerrors.Errorf("sending reader param: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("sending reader param: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return xerrors.Errorf("sending reader param (%s): non-200 status: %s, msg: '%s'", u.String(), resp.Status, string(b))
+	}
+
+	return nil
 }
@@ -0,0 +1,153 @@
+package titan
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// predictedPortCount is the number of ports predicted for a symmetric NAT
+	predictedPortCount = 16
+	// probeRounds is the number of packets sent to each predicted port
+	probeRounds   = 3
+	probeInterval = 20 * time.Millisecond
+)
+
+// errNoPredictedPort means the NAT of the edge did not allocate any of the predicted ports towards the user.
+var errNoPredictedPort = errors.New("none of the predicted ports is reachable")
+
+// predictPorts guesses the next ports the NAT of the edge allocates from the port its scheduler observed, which is
+// the port of the edge address. Most symmetric NATs allocate ports sequentially, so the mapping towards the user is
+// likely one of the ports right after it. The scheduler reports a single observation of the edge, so the stride of
+// the allocation can not be detected, a NAT allocating by a stride larger than the count is not traversed.
+func predictPorts(observed int, count int) ([]int, error) {
+	if observed <= 0 || observed > 65535 {
+		return nil, errors.Errorf("invalid observed port %d", observed)
+	}
+
+	var ports []int
+	for port := observed + 1; port <= 65535 && len(ports) < count; port++ {
+		ports = append(ports, port)
+	}
+
+	if len(ports) == 0 {
+		return nil, errors.Errorf("no port to predict after %d", observed)
+	}
+
+	return ports, nil
+}
+
+// sendProbes sends a burst of udp packets from the service connection to the ports, so the NAT of the user side
+// creates the mappings towards them and lets the packets of the edge in.
+func (s *Service) sendProbes(ctx context.Context, ip string, ports []int) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for round := 0; round < probeRounds; round++ {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		for _, port := range ports {
+			addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+			if _, err := s.conn.WriteTo([]byte{0}, addr); err != nil {
+				log.Debugf("send probe to %s failed: %v", addr, err)
+			}
+		}
+		timer.Reset(probeInterval)
+	}
+
+	return nil
+}
+
+// traverseSymmetricNAT connects to an edge behind a symmetric NAT by port prediction. The ports following the port
+// of the edge address are probed while the edge punches the user side, then all the predicted ports are dialed
+// at the same time, the first connection established wins.
+func (s *Service) traverseSymmetricNAT(ctx context.Context, edge *types.Edge) (*http.Client, error) {
+	ip, portStr, err := net.SplitHostPort(edge.Address)
+	if err != nil {
+		return nil, errors.Errorf("invalid edge address %s: %v", edge.Address, err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.Errorf("invalid edge address %s: %v", edge.Address, err)
+	}
+
+	ports, err := predictPorts(port, predictedPortCount)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("predicted ports of edge %s: %v", edge.NodeID, ports)
+
+	if err = s.sendProbes(ctx, ip, ports); err != nil {
+		return nil, err
+	}
+
+	if err = s.EstablishConnectionFromEdge(ctx, edge); err != nil {
+		return nil, errors.Errorf("establish connection from edge: %v", err)
+	}
+
+	conn, err := s.dialAny(ctx, ip, ports, s.identities.edgeTLSConfig(edge.NodeID))
+	if err != nil {
+		return nil, errors.Wrap(err, "port prediction failed")
+	}
+
+	return newHttpClient(conn, s.timeout), nil
+}
+
+// dialAny dials the ports concurrently and returns the first connection established, the others are closed.
+func (s *Service) dialAny(ctx context.Context, ip string, ports []int, tlsConf *tls.Config) (quic.EarlyConnection, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		winner quic.EarlyConnection
+	)
+
+	for _, port := range ports {
+		wg.Add(1)
+
+		go func(port int) {
+			defer wg.Done()
+
+			addr := net.JoinHostPort(ip, strconv.Itoa(port))
+			conn, err := createConnection(ctx, s.conn, addr, tlsConf.Clone())
+			if err != nil {
+				log.Debugf("dial predicted address %s failed: %v", addr, err)
+				return
+			}
+
+			won := false
+			once.Do(func() {
+				winner, won = conn, true
+				cancel()
+			})
+
+			if !won {
+				conn.CloseWithError(0, "")
+			}
+		}(port)
+	}
+
+	wg.Wait()
+
+	if winner == nil {
+		return nil, errors.Wrapf(errNoPredictedPort, "%d ports dialed", len(ports))
+	}
+
+	return winner, nil
+}
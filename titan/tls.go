@@ -0,0 +1,258 @@
+package titan
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/internal/request"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// identities tells the nodes of the Titan network apart from the locator and the schedulers. The locator and the
+// schedulers serve certificates issued by a CA, while the edges and the candidates serve self-signed certificates,
+// so an edge can only be authenticated by the public key its scheduler advertises for the node id.
+type identities struct {
+	policy config.TLSPolicy
+	roots  *x509.CertPool
+
+	lk sync.Mutex
+	// nodes are the addresses of the candidates, their certificates are not verified
+	nodes map[string]struct{}
+	// edges maps the addresses of the edges to the node ids behind them, several nodes may share an address
+	edges map[string]map[string]struct{}
+	// keys are the public keys of the edges advertised by their schedulers, keyed by node id
+	keys map[string]crypto.PublicKey
+}
+
+func newIdentities(policy config.TLSPolicy, roots *x509.CertPool) *identities {
+	return &identities{
+		policy: policy,
+		roots:  roots,
+		nodes:  make(map[string]struct{}),
+		edges:  make(map[string]map[string]struct{}),
+		keys:   make(map[string]crypto.PublicKey),
+	}
+}
+
+// addNodes registers the urls or the addresses of nodes which are not edges, e.g. the candidates.
+func (ids *identities) addNodes(addrs ...string) {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	for _, addr := range addrs {
+		if addr = nodeAddr(addr); addr != "" {
+			ids.nodes[addr] = struct{}{}
+		}
+	}
+}
+
+func (ids *identities) addEdge(edge *types.Edge) {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	addr := nodeAddr(edge.Address)
+	if addr == "" {
+		return
+	}
+
+	if ids.edges[addr] == nil {
+		ids.edges[addr] = make(map[string]struct{})
+	}
+	ids.edges[addr][edge.NodeID] = struct{}{}
+}
+
+// edgesOf returns the node ids of the edges behind the address.
+func (ids *identities) edgesOf(addr string) []string {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	nodeIDs := make([]string, 0, len(ids.edges[addr]))
+	for nodeID := range ids.edges[addr] {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs
+}
+
+func (ids *identities) key(nodeID string) (crypto.PublicKey, bool) {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	key, ok := ids.keys[nodeID]
+	return key, ok
+}
+
+func (ids *identities) setKey(nodeID string, key crypto.PublicKey) {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	ids.keys[nodeID] = key
+}
+
+// tlsConfig returns the TLS configuration to dial the address, `host:port`, by what the address is known as.
+func (ids *identities) tlsConfig(addr string) *tls.Config {
+	if ids.policy == config.TLSPolicyInsecure {
+		return insecureTLSConf()
+	}
+
+	addr = nodeAddr(addr)
+
+	nodeIDs := ids.edgesOf(addr)
+
+	ids.lk.Lock()
+	_, isNode := ids.nodes[addr]
+	ids.lk.Unlock()
+
+	switch {
+	case len(nodeIDs) > 0:
+		return ids.edgeTLSConfig(nodeIDs...)
+	case isNode:
+		return insecureTLSConf()
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		RootCAs:    ids.roots,
+		NextProtos: []string{http3.NextProtoH3},
+	}
+}
+
+// edgeTLSConfig returns the TLS configuration to dial the edges behind an address, the certificate is pinned to
+// the public key of one of the edges if the policy says so.
+func (ids *identities) edgeTLSConfig(nodeIDs ...string) *tls.Config {
+	conf := insecureTLSConf()
+	if ids.policy != config.TLSPolicyPinEdges {
+		return conf
+	}
+
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return ids.verifyEdge(nodeIDs, rawCerts)
+	}
+
+	return conf
+}
+
+// verifyEdge checks the certificate presented holds the public key one of the edges is advertised with by its scheduler.
+func (ids *identities) verifyEdge(nodeIDs []string, rawCerts [][]byte) error {
+	name := strings.Join(nodeIDs, ",")
+	if len(rawCerts) == 0 {
+		return errors.Errorf("edge %s presented no certificate", name)
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return errors.Errorf("parse certificate of edge %s: %v", name, err)
+	}
+
+	pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return errors.Errorf("certificate of edge %s holds an unsupported public key", name)
+	}
+
+	for _, nodeID := range nodeIDs {
+		if key, ok := ids.key(nodeID); ok && pub.Equal(key) {
+			return nil
+		}
+	}
+
+	return errors.Errorf("certificate of edge %s does not match its public key", name)
+}
+
+// GetNodePublicKey get the public key of the node from its scheduler.
+func (s *Service) GetNodePublicKey(ctx context.Context, schedulerURL, nodeID string) (crypto.PublicKey, error) {
+	serializedParams, err := json.Marshal(params{nodeID})
+	if err != nil {
+		return nil, errors.Errorf("marshaling params failed: %v", err)
+	}
+
+	req := request.Request{
+		Jsonrpc: "2.0",
+		ID:      "1",
+		Method:  "titan.GetNodePublicKey",
+		Params:  serializedParams,
+	}
+
+	data, err := request.PostJsonRPC(ctx, s.httpClient, schedulerURL, req, nil)
+	if err != nil {
+		return nil, errors.Errorf("get public key of node %s failed: %v", nodeID, err)
+	}
+
+	var out string
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	return parsePublicKey(out)
+}
+
+// pinEdge loads the public key of the edge the connections to it are pinned to, if the policy pins the edges.
+func (s *Service) pinEdge(ctx context.Context, edge *types.Edge) error {
+	if s.identities.policy != config.TLSPolicyPinEdges {
+		return nil
+	}
+
+	if _, ok := s.identities.key(edge.NodeID); ok {
+		return nil
+	}
+
+	key, err := s.GetNodePublicKey(ctx, edge.SchedulerURL, edge.NodeID)
+	if err != nil {
+		return err
+	}
+
+	s.identities.setKey(edge.NodeID, key)
+	return nil
+}
+
+// parsePublicKey parses a PEM encoded public key, either PKCS #1 or PKIX.
+func parsePublicKey(key string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(bytes.TrimSpace([]byte(key)))
+	if block == nil {
+		return nil, errors.Errorf("invalid public key, want PEM")
+	}
+
+	if pub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Errorf("parse public key: %v", err)
+	}
+
+	return pub, nil
+}
+
+// nodeAddr returns the `host:port` of a node url or address, the port defaults to 443 like the HTTP/3 client does.
+func nodeAddr(addr string) string {
+	if strings.Contains(addr, "://") {
+		host, err := candidateHost(addr)
+		if err != nil {
+			return ""
+		}
+		addr = host
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+
+	return addr
+}
@@ -0,0 +1,55 @@
+package titan
+
+import (
+	"crypto/x509"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"testing"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		edges  []titantest.EdgeOptions
+		policy config.TLSPolicy
+		// untrusted verifies the locator and the schedulers against roots not holding their CA
+		untrusted bool
+		wantErr   bool
+		// wantServed tells whether each edge served the block
+		wantServed []bool
+	}{
+		{"impostor not pinned", []titantest.EdgeOptions{{Impostor: true}}, config.TLSPolicyVerify, false, false, []bool{true}},
+		{"edge pinned", []titantest.EdgeOptions{{}}, config.TLSPolicyPinEdges, false, false, []bool{true}},
+		{"impostor pinned", []titantest.EdgeOptions{{Impostor: true}}, config.TLSPolicyPinEdges, false, true, []bool{false}},
+		{"impostor pinned among edges", []titantest.EdgeOptions{{Impostor: true}, {}}, config.TLSPolicyPinEdges, false, false, []bool{false, true}},
+		{"locator not trusted", []titantest.EdgeOptions{{}}, config.TLSPolicyVerify, true, true, []bool{false}},
+		{"locator not verified", []titantest.EdgeOptions{{}}, config.TLSPolicyInsecure, true, false, []bool{true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, root := newTestFile(t, titantest.WithEdges(tt.edges...))
+
+			opts := []config.Option{config.TLSPolicyOption(tt.policy)}
+			if tt.untrusted {
+				opts = append(opts, config.RootCAsOption(x509.NewCertPool()))
+			}
+			s := newTestService(t, network, opts...)
+
+			session := s.NewSession(root)
+			defer session.Close()
+
+			_, err := session.GetBlock(testContext(t), root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("get block: err = %v, want error %v", err, tt.wantErr)
+			}
+
+			for i, edge := range network.Edges() {
+				requests, _ := edge.Served()
+				if served := requests > 0; served != tt.wantServed[i] {
+					t.Errorf("edge %s served = %v, want %v", edge.NodeID, served, tt.wantServed[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package titan
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// tcpFallbackInterval is how long a host HTTP/3 timed out for is reached over TCP
+	tcpFallbackInterval = 10 * time.Minute
+	// tcpDialTimeout bounds the TCP and TLS handshakes of the fallback transport
+	tcpDialTimeout = 10 * time.Second
+)
+
+type networkKey struct{}
+
+// withNetwork makes the requests of the context use the network, udp or tcp, whatever the transport would choose.
+// The NAT discovery tests each network by itself.
+func withNetwork(ctx context.Context, network string) context.Context {
+	return context.WithValue(ctx, networkKey{}, network)
+}
+
+func networkOf(ctx context.Context) string {
+	network, _ := ctx.Value(networkKey{}).(string)
+	return network
+}
+
+// transport sends the requests over HTTP/3, and over HTTPS on TCP to the hosts HTTP/3 times out for. Once the NAT
+// discovery found that UDP is blocked, every request goes over TCP right away. Only the locator, the schedulers,
+// the candidates and the directly reachable edges can be reached over TCP, the NAT traversal needs UDP.
+type transport struct {
+	h3  *http3.RoundTripper
+	tcp *http.Transport
+	nat *natCache
+
+	lk sync.Mutex
+	// tcpHosts are the hosts reached over TCP until the time, since HTTP/3 timed out for them
+	tcpHosts map[string]time.Time
+}
+
+func newTransport(conn net.PacketConn, ids *identities, nat *natCache) *transport {
+	dialer := &net.Dialer{Timeout: tcpDialTimeout}
+
+	return &transport{
+		h3: &http3.RoundTripper{
+			QuicConfig: defaultQUICConfig(),
+			Dial: func(ctx context.Context, addr string, _ *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+				address, err := net.ResolveUDPAddr("udp", addr)
+				if err != nil {
+					return nil, err
+				}
+
+				qconn, err := quic.DialEarlyContext(ctx, conn, address, addr, ids.tlsConfig(addr), cfg)
+				if err != nil {
+					return nil, &dialError{err}
+				}
+				return qconn, nil
+			},
+		},
+		tcp: &http.Transport{
+			DialContext: dialer.DialContext,
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conf := ids.tlsConfig(addr)
+				conf.NextProtos = []string{"h2", "http/1.1"}
+
+				d := &tls.Dialer{NetDialer: dialer, Config: conf}
+				return d.DialContext(ctx, network, addr)
+			},
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: tcpDialTimeout,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		nat:      nat,
+		tcpHosts: make(map[string]time.Time),
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch networkOf(req.Context()) {
+	case "udp":
+		return t.h3.RoundTrip(req)
+	case "tcp":
+		return t.tcp.RoundTrip(req)
+	}
+
+	if t.useTCP(req.URL.Host) {
+		return t.tcp.RoundTrip(req)
+	}
+
+	resp, err := t.h3.RoundTrip(req)
+	if err == nil || !isDialTimeout(err) || req.Context().Err() != nil {
+		return resp, err
+	}
+
+	log.Debugf("HTTP/3 to %s timed out, fall back to TCP: %v", req.URL.Host, err)
+	t.fallback(req.URL.Host)
+
+	// the body was not sent since no connection was established, it is sent over TCP if it can be read again
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+
+		body, e := req.GetBody()
+		if e != nil {
+			return nil, err
+		}
+
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	return t.tcp.RoundTrip(req)
+}
+
+// useTCP returns true if the requests to the host should go over TCP.
+func (t *transport) useTCP(host string) bool {
+	if t.nat.natType() == udpBlock {
+		return true
+	}
+
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	until, ok := t.tcpHosts[host]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(until) {
+		delete(t.tcpHosts, host)
+		return false
+	}
+
+	return true
+}
+
+func (t *transport) fallback(host string) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	t.tcpHosts[host] = time.Now().Add(tcpFallbackInterval)
+}
+
+func (t *transport) Close() error {
+	t.tcp.CloseIdleConnections()
+	return t.h3.Close()
+}
+
+// dialError is the error of a QUIC connection which could not be established, nothing was sent over it.
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
+// isDialTimeout returns true if the QUIC handshake got no answer, as when UDP is blocked.
+func isDialTimeout(err error) bool {
+	var dialErr *dialError
+	if !errors.As(err, &dialErr) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(dialErr.err, &netErr) && netErr.Timeout()
+}
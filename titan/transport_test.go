@@ -0,0 +1,58 @@
+package titan
+
+import (
+	"bytes"
+	"github.com/gnasnik/titan-sdk-go/titantest"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"testing"
+)
+
+func TestTCPFallback(t *testing.T) {
+	// waits for the QUIC handshakes to time out
+	t.Parallel()
+
+	network, root := newTestFile(t,
+		titantest.WithClientNAT(types.NATUDPBlock),
+		titantest.WithEdges(titantest.EdgeOptions{NATType: "NoNAT"}, titantest.EdgeOptions{NATType: "RestrictedNAT"}),
+	)
+	car, _ := network.CAR(root)
+	s := newTestService(t, network)
+
+	getRange := func(t *testing.T, session *Session) {
+		_, data, err := session.GetRange(testContext(t), root, 0, 1023)
+		if err != nil {
+			t.Fatalf("get range: %v", err)
+		}
+		if !bytes.Equal(data, car[:1024]) {
+			t.Errorf("range does not match the car file")
+		}
+	}
+
+	// the QUIC handshakes time out while the NAT type is discovered, the hosts fall back to TCP one by one
+	t.Run("before discovery", func(t *testing.T) {
+		session := s.NewSession(root)
+		defer session.Close()
+
+		getRange(t, session)
+	})
+
+	t.Run("after discovery", func(t *testing.T) {
+		s.discoverIfDue()
+		if natType := s.NATType(); natType != udpBlock {
+			t.Fatalf("NAT type = %s, want %s", natType, udpBlock)
+		}
+
+		session := s.NewSession(root)
+		defer session.Close()
+
+		getRange(t, session)
+
+		// the edge behind a NAT can only be reached by a traversal, which needs UDP
+		if size := session.EdgeSize(); size != 1 {
+			t.Errorf("edges = %d, want 1", size)
+		}
+		if requests, _ := network.Edges()[1].Served(); requests != 0 {
+			t.Errorf("edge behind a NAT served %d requests, want 0", requests)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package titan
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gnasnik/titan-sdk-go/internal/request"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/pkg/errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// CreateAsset asks a scheduler of the area for the candidates to upload the asset to.
+func (s *Service) CreateAsset(ctx context.Context, asset *types.AssetProperty) (*types.UploadInfo, error) {
+	serializedParams, err := json.Marshal(params{asset})
+	if err != nil {
+		return nil, errors.Errorf("marshaling params failed: %v", err)
+	}
+
+	req := request.Request{
+		Jsonrpc: "2.0",
+		ID:      "1",
+		Method:  "titan.CreateAsset",
+		Params:  serializedParams,
+	}
+
+	header := http.Header{}
+	if s.token != "" {
+		header.Add("Authorization", "Bearer "+s.token)
+	}
+
+	data, _, err := s.callScheduler(ctx, req, header)
+	if err != nil {
+		return nil, errors.Errorf("create asset failed: %v", err)
+	}
+
+	var out types.UploadInfo
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	for _, node := range out.List {
+		s.identities.addNodes(node.UploadURL)
+	}
+
+	return &out, nil
+}
+
+// UploadCAR pushes a CAR file to the candidate as a multipart form, the data is streamed from the reader.
+func (s *Service) UploadCAR(ctx context.Context, node *types.NodeUploadInfo, name string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		part, err := mw.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.UploadURL, pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+node.Token)
+
+	// the upload may take much longer than the timeout of the rpc client
+	client := &http.Client{Transport: s.httpClient.Transport}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		pr.Close()
+		return errors.Errorf("upload to %s failed: %v", node.NodeID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("upload to %s failed, status code %d: %s", node.NodeID, resp.StatusCode, string(body))
+	}
+
+	return nil
+}
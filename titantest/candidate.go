@@ -0,0 +1,212 @@
+package titantest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	primaryCandidate  = 0
+	tertiaryCandidate = 2
+
+	connectivityTimeout = 3 * time.Second
+)
+
+// Candidate is a fake candidate node, it tells the client its public address, checks the connectivity of the client
+// for the NAT discovery and accepts the uploads.
+type Candidate struct {
+	NodeID string
+
+	index     int
+	network   *Network
+	server    *server
+	transport *http3.RoundTripper
+	// tcpTransport pings the client over TCP
+	tcpTransport *http.Transport
+
+	lk sync.Mutex
+	// observed is the number of the public addresses told to the clients
+	observed int
+}
+
+func newCandidate(n *Network, index int) (*Candidate, error) {
+	c := &Candidate{
+		NodeID:  fmt.Sprintf("c_%d", index),
+		index:   index,
+		network: n,
+		transport: &http3.RoundTripper{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		},
+		tcpTransport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", rpcServer{
+		"titan.GetExternalAddress":       c.getExternalAddress,
+		"titan.CheckNetworkConnectivity": c.checkNetworkConnectivity,
+		"titan.Version": func(r *http.Request, params []json.RawMessage) (interface{}, error) {
+			return edgeVersion, nil
+		},
+	})
+	mux.HandleFunc("/upload", c.upload)
+
+	server, err := n.newServer(mux, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.server = server
+
+	return c, nil
+}
+
+// Address returns the `ip:port` of the candidate.
+func (c *Candidate) Address() string {
+	return c.server.addr()
+}
+
+// Observed returns the number of the public addresses the candidate told the clients, the primary candidate tells
+// one in every NAT discovery.
+func (c *Candidate) Observed() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.observed
+}
+
+func (c *Candidate) uploadURL() string {
+	return fmt.Sprintf("https://%s/upload", c.Address())
+}
+
+func (c *Candidate) close() {
+	c.transport.Close()
+	c.tcpTransport.CloseIdleConnections()
+	c.server.close()
+}
+
+// getExternalAddress returns the address the request comes from, a symmetric NAT maps the client to a different
+// port for each candidate.
+func (c *Candidate) getExternalAddress(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	c.lk.Lock()
+	c.observed++
+	c.lk.Unlock()
+
+	if c.network.options.ClientNAT != types.NATSymmetric {
+		return r.RemoteAddr, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, _ := strconv.Atoi(portStr)
+	return net.JoinHostPort(host, strconv.Itoa(port+c.index)), nil
+}
+
+// checkNetworkConnectivity sends a packet to the client if the simulated NAT of the client lets it through.
+func (c *Candidate) checkNetworkConnectivity(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var network, url string
+	if len(params) != 2 || json.Unmarshal(params[0], &network) != nil || json.Unmarshal(params[1], &url) != nil {
+		return nil, errors.Errorf("invalid params")
+	}
+
+	if !c.reachable(network) {
+		return nil, errors.Errorf("%s packets to %s are dropped by the NAT", network, url)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), connectivityTimeout)
+	defer cancel()
+
+	// the client serves the ping over HTTP/3 and over HTTPS on TCP on the same port
+	var transport http.RoundTripper
+	switch network {
+	case "tcp":
+		transport = c.tcpTransport
+	case "udp":
+		transport = c.transport
+	default:
+		return nil, errors.Errorf("unknown network %s", network)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("ping status code %d", resp.StatusCode)
+	}
+
+	return nil, nil
+}
+
+// reachable reports whether a packet sent by the candidate reaches the client, following the tests of the
+// NAT discovery of the client.
+func (c *Candidate) reachable(network string) bool {
+	natType := c.network.options.ClientNAT
+
+	switch {
+	case network == "tcp" && c.index == tertiaryCandidate:
+		return natType == types.NATOpenInternet
+	case network == "udp" && c.index == tertiaryCandidate:
+		return natType == types.NATOpenInternet || natType == types.NATFullCone
+	case network == "udp" && c.index == primaryCandidate:
+		return natType == types.NATOpenInternet || natType == types.NATFullCone || natType == types.NATRestricted
+	default:
+		return false
+	}
+}
+
+// upload adds the CAR file posted as the `file` field of a multipart form to the network.
+func (c *Candidate) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if owner, ok := c.network.tokenOwner(token); !ok || owner != c.NodeID {
+		http.Error(w, "token is not issued for the candidate", http.StatusUnauthorized)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err = c.network.AddCAR(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
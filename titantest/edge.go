@@ -0,0 +1,227 @@
+package titantest
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/internal/codec"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/ipfs/go-cid"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	edgeVersion = "titantest-edge"
+)
+
+// EdgeOptions configures the behavior of an edge.
+type EdgeOptions struct {
+	// NATType is the NAT type of the edge reported by the scheduler, e.g. "NoNAT", "RestrictedNAT" or "SymmetricNAT",
+	// default "NoNAT".
+	NATType string
+	// PortStride is the difference between the ports a symmetric NAT allocates for two destinations in a row,
+	// default 1.
+	PortStride int
+	// Corrupt makes the edge flip a byte of every response, to test content verification.
+	Corrupt bool
+	// Fail makes the edge answer every data request with an error.
+	Fail bool
+	// Impostor makes the edge present a certificate of another key than the one its scheduler advertises, as if
+	// another node took over its address, to test the pinning of the edges.
+	Impostor bool
+}
+
+// Edge is a fake edge node serving the blocks and CAR files of the network.
+type Edge struct {
+	NodeID  string
+	Options EdgeOptions
+
+	network *Network
+	server  *server
+	handler http.Handler
+	key     crypto.Signer
+
+	lk       sync.Mutex
+	requests int
+	served   int64
+}
+
+func newEdge(n *Network, nodeID string, opts EdgeOptions) (*Edge, error) {
+	if opts.NATType == "" {
+		opts.NATType = "NoNAT"
+	}
+	if opts.PortStride == 0 {
+		opts.PortStride = 1
+	}
+
+	e := &Edge{
+		NodeID:  nodeID,
+		Options: opts,
+		network: n,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", rpcServer{
+		"titan.Version": func(r *http.Request, params []json.RawMessage) (interface{}, error) {
+			return edgeVersion, nil
+		},
+	})
+	mux.HandleFunc("/ipfs/", e.serveData)
+
+	e.handler = mux
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	e.key = key
+
+	// the impostor presents a key of its own
+	if opts.Impostor {
+		key = nil
+	}
+
+	server, err := n.newServer(e.handler, key)
+	if err != nil {
+		return nil, err
+	}
+	e.server = server
+
+	return e, nil
+}
+
+// Address returns the `ip:port` of the edge.
+func (e *Edge) Address() string {
+	return e.server.addr()
+}
+
+// Served returns the number of data requests served and the bytes sent by the edge.
+func (e *Edge) Served() (int, int64) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	return e.requests, e.served
+}
+
+// advertisedAddress returns the address the scheduler observed for the edge. A symmetric NAT allocates another port
+// for every destination by the stride of the options, the edge is served on the port allocated towards the client,
+// so the scheduler observed the port one stride before it.
+func (e *Edge) advertisedAddress() string {
+	if e.Options.NATType != "SymmetricNAT" {
+		return e.Address()
+	}
+
+	host, portStr, _ := net.SplitHostPort(e.Address())
+	port, _ := strconv.Atoi(portStr)
+
+	return net.JoinHostPort(host, strconv.Itoa(port-e.Options.PortStride))
+}
+
+func (e *Edge) serveData(w http.ResponseWriter, r *http.Request) {
+	if e.Options.Fail {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var token types.Token
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = codec.Decode(body, &token)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	if owner, ok := e.network.tokenOwner(token.ID); !ok || owner != e.NodeID {
+		http.Error(w, "token is not issued for the edge", http.StatusUnauthorized)
+		return
+	}
+
+	c, err := cid.Decode(strings.TrimPrefix(r.URL.Path, "/ipfs/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "raw":
+		data, ok := e.network.getBlock(c)
+		if !ok {
+			http.Error(w, "block not found", http.StatusNotFound)
+			return
+		}
+		e.write(w, http.StatusOK, data)
+	case "car":
+		data, ok := e.network.CAR(c)
+		if !ok {
+			http.Error(w, "car not found", http.StatusNotFound)
+			return
+		}
+
+		start, end, ok := parseRange(r.Header.Get("Range"), int64(len(data)))
+		if !ok {
+			e.write(w, http.StatusOK, data)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		e.write(w, http.StatusPartialContent, data[start:end+1])
+	default:
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+	}
+}
+
+func (e *Edge) write(w http.ResponseWriter, status int, data []byte) {
+	if e.Options.Corrupt && len(data) > 0 {
+		corrupted := make([]byte, len(data))
+		copy(corrupted, data)
+		corrupted[len(corrupted)/2] ^= 0xff
+		data = corrupted
+	}
+
+	e.lk.Lock()
+	e.requests++
+	e.served += int64(len(data))
+	e.lk.Unlock()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// parseRange parses a single `bytes=start-end` range and clamps it to the size.
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || size == 0 {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+
+	if end >= size {
+		end = size - 1
+	}
+
+	return start, end, true
+}
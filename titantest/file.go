@@ -0,0 +1,284 @@
+package titantest
+
+import (
+	"bytes"
+	"context"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	mdutils "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/hamt"
+	unixfs_pb "github.com/ipfs/go-unixfs/pb"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/pkg/errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxLinks is the number of links of a node of the file DAG, the same as the balanced layout of go-unixfs.
+	maxLinks = 174
+	// shardWidth is the width of the HAMT-sharded directories, small so the shards of a few entries nest
+	shardWidth = 8
+)
+
+// dagNode is a node of the file DAG with its children, in the order they are packed into the CAR file.
+type dagNode struct {
+	node     ipld.Node
+	children []*dagNode
+	size     uint64
+}
+
+// AddFile chunks the data into a UnixFS file of raw leaves of chunkSize bytes, packs the DAG into a CARv1 file
+// in DFS pre-order like the edges do and adds it, see AddCAR. It returns the root of the file.
+func (n *Network) AddFile(data []byte, chunkSize int) (cid.Cid, error) {
+	root, err := newFileDAG(data, chunkSize)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	var buf bytes.Buffer
+	w, err := storage.NewWritable(&buf, []cid.Cid{root.node.Cid()}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err = putDAG(w, root); err != nil {
+		return cid.Undef, err
+	}
+
+	if err = w.Finalize(); err != nil {
+		return cid.Undef, err
+	}
+
+	return n.AddCAR(buf.Bytes())
+}
+
+// AddDirectory adds a UnixFS directory of the files keyed by their slash separated paths, the subdirectories are
+// created from the paths and the files are chunked like AddFile. The directories are HAMT-sharded if sharded is true.
+// It returns the root of the directory.
+func (n *Network) AddDirectory(files map[string][]byte, chunkSize int, sharded bool) (cid.Cid, error) {
+	ctx := context.Background()
+	dag := mdutils.Mock()
+
+	root, err := newDirectoryDAG(ctx, dag, files, chunkSize, sharded)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	var buf bytes.Buffer
+	w, err := storage.NewWritable(&buf, []cid.Cid{root.Cid()}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err = putDAGService(ctx, w, dag, root.Cid(), cid.NewSet()); err != nil {
+		return cid.Undef, err
+	}
+
+	if err = w.Finalize(); err != nil {
+		return cid.Undef, err
+	}
+
+	return n.AddCAR(buf.Bytes())
+}
+
+// AddRandomFile adds a file of random data, see AddFile. The chunks of random data differ, so none is deduplicated
+// in the CAR file. It returns the root and the data of the file.
+func (n *Network) AddRandomFile(size, chunkSize int) (cid.Cid, []byte, error) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+
+	root, err := n.AddFile(data, chunkSize)
+	return root, data, err
+}
+
+// newFileDAG chunks the data into the DAG of a UnixFS file of raw leaves.
+func newFileDAG(data []byte, chunkSize int) (*dagNode, error) {
+	if chunkSize <= 0 {
+		return nil, errors.Errorf("invalid chunk size: %d", chunkSize)
+	}
+
+	var level []*dagNode
+	for offset := 0; offset < len(data); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		leaf := merkledag.NewRawNode(data[offset:end])
+		level = append(level, &dagNode{node: leaf, size: uint64(end - offset)})
+	}
+
+	// the root is a dag-pb node even if the file fits in a leaf
+	for {
+		var parents []*dagNode
+		for start := 0; start < len(level) || start == 0; start += maxLinks {
+			end := start + maxLinks
+			if end > len(level) {
+				end = len(level)
+			}
+
+			parent, err := newFileNode(level[start:end])
+			if err != nil {
+				return nil, err
+			}
+			parents = append(parents, parent)
+		}
+
+		level = parents
+		if len(level) == 1 {
+			break
+		}
+	}
+
+	return level[0], nil
+}
+
+// newDirectoryDAG adds the nodes of the directory of the files to the DAG service and returns the root of it.
+func newDirectoryDAG(ctx context.Context, dag ipld.DAGService, files map[string][]byte, chunkSize int, sharded bool) (ipld.Node, error) {
+	entries := make(map[string]ipld.Node)
+	subdirs := make(map[string]map[string][]byte)
+
+	for path, data := range files {
+		name, rest, isDir := strings.Cut(path, "/")
+		if isDir {
+			if subdirs[name] == nil {
+				subdirs[name] = make(map[string][]byte)
+			}
+			subdirs[name][rest] = data
+			continue
+		}
+
+		file, err := newFileDAG(data, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if err = addDAG(ctx, dag, file); err != nil {
+			return nil, err
+		}
+		entries[name] = file.node
+	}
+
+	for name, subdir := range subdirs {
+		node, err := newDirectoryDAG(ctx, dag, subdir, chunkSize, sharded)
+		if err != nil {
+			return nil, err
+		}
+		entries[name] = node
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var dir ipld.Node
+	if sharded {
+		shard, err := hamt.NewShard(dag, shardWidth)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			if err = shard.Set(ctx, name, entries[name]); err != nil {
+				return nil, err
+			}
+		}
+
+		if dir, err = shard.Node(); err != nil {
+			return nil, err
+		}
+	} else {
+		node := merkledag.NodeWithData(unixfs.FolderPBData())
+		for _, name := range names {
+			if err := node.AddNodeLink(name, entries[name]); err != nil {
+				return nil, err
+			}
+		}
+		dir = node
+	}
+
+	return dir, dag.Add(ctx, dir)
+}
+
+// newFileNode creates the UnixFS file node linking the children.
+func newFileNode(children []*dagNode) (*dagNode, error) {
+	fsNode := unixfs.NewFSNode(unixfs_pb.Data_File)
+	node := &merkledag.ProtoNode{}
+
+	for _, child := range children {
+		fsNode.AddBlockSize(child.size)
+		if err := node.AddNodeLink("", child.node); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := fsNode.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+	node.SetData(data)
+
+	return &dagNode{node: node, children: children, size: fsNode.FileSize()}, nil
+}
+
+// addDAG adds the node and its children to the DAG service.
+func addDAG(ctx context.Context, dag ipld.DAGService, node *dagNode) error {
+	if err := dag.Add(ctx, node.node); err != nil {
+		return err
+	}
+
+	for _, child := range node.children {
+		if err := addDAG(ctx, dag, child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putDAG writes the node then its children, in DFS pre-order.
+func putDAG(w storage.WritableCar, node *dagNode) error {
+	if err := w.Put(context.Background(), node.node.Cid().KeyString(), node.node.RawData()); err != nil {
+		return err
+	}
+
+	for _, child := range node.children {
+		if err := putDAG(w, child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putDAGService writes the node of the DAG service then the nodes it links, in DFS pre-order, a node linked more than
+// once is only written the first time.
+func putDAGService(ctx context.Context, w storage.WritableCar, dag ipld.DAGService, c cid.Cid, written *cid.Set) error {
+	if !written.Visit(c) {
+		return nil
+	}
+
+	node, err := dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err = w.Put(ctx, c.KeyString(), node.RawData()); err != nil {
+		return err
+	}
+
+	for _, link := range node.Links() {
+		if err = putDAGService(ctx, w, dag, link.Cid, written); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package titantest
+
+import (
+	"encoding/json"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// locator is the fake locator of the network, it tells the clients the schedulers of their area and the edges
+// holding a file.
+type locator struct {
+	network *Network
+	server  *server
+}
+
+func newLocator(n *Network) (*locator, error) {
+	l := &locator{network: n}
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", rpcServer{
+		"titan.GetUserAccessPoint": l.getUserAccessPoint,
+		"titan.EdgeDownloadInfos":  l.edgeDownloadInfos,
+	})
+
+	server, err := n.newServer(mux, nil)
+	if err != nil {
+		return nil, err
+	}
+	l.server = server
+
+	return l, nil
+}
+
+func (l *locator) getUserAccessPoint(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	ap := types.AccessPoint{AreaID: "titantest"}
+	for _, scheduler := range l.network.schedulers {
+		ap.SchedulerURLs = append(ap.SchedulerURLs, scheduler.URL())
+	}
+	return ap, nil
+}
+
+func (l *locator) edgeDownloadInfos(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var cidStr string
+	if len(params) == 0 || json.Unmarshal(params[0], &cidStr) != nil {
+		return nil, errors.Errorf("invalid params")
+	}
+
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*types.EdgeDownloadInfoList{}
+	if !l.network.has(c) {
+		return out, nil
+	}
+
+	for _, scheduler := range l.network.schedulers {
+		if list := scheduler.edgeDownloadInfos(); list != nil {
+			out = append(out, list)
+		}
+	}
+
+	return out, nil
+}
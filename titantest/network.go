@@ -0,0 +1,322 @@
+// Package titantest provides an in-process Titan network for tests. The locator, schedulers, candidates and edges are fake
+// nodes serving HTTP/3 on the loopback interface and speaking the same JSON-RPC and gateway protocols as the real
+// nodes, so downloads, NAT traversal and the submission of workload reports can be exercised offline.
+//
+//	network, err := titantest.NewNetwork(titantest.WithEdges(titantest.EdgeOptions{NATType: "NoNAT"}))
+//	root, err := network.AddCARFile("testdata/file.car")
+//	client, err := titan.New(network.ClientOptions()...)
+//	defer client.Close()
+package titantest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/config"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var log = logging.Logger("titantest")
+
+// Options configures the fake network.
+type Options struct {
+	// Schedulers is the number of schedulers in the access point of the client.
+	Schedulers int
+	// Candidates is the number of candidates, the NAT discovery of the client needs at least 3.
+	Candidates int
+	// Edges configures the edges holding the files, one edge per item.
+	Edges []EdgeOptions
+	// ClientNAT is the NAT type the candidates make the client discover.
+	ClientNAT types.NATType
+}
+
+// Option is a single option of the fake network.
+type Option func(opts *Options)
+
+// WithSchedulers set the number of schedulers, default 1. The edges are assigned to the schedulers in turn.
+func WithSchedulers(n int) Option {
+	return func(opts *Options) {
+		opts.Schedulers = n
+	}
+}
+
+// WithCandidates set the number of candidates, default 3.
+func WithCandidates(n int) Option {
+	return func(opts *Options) {
+		opts.Candidates = n
+	}
+}
+
+// WithEdges set the edges of the network, default 3 edges without NAT.
+func WithEdges(edges ...EdgeOptions) Option {
+	return func(opts *Options) {
+		opts.Edges = edges
+	}
+}
+
+// WithClientNAT set the NAT type the client discovers, default `types.NATFullCone`. The candidates only answer
+// the connectivity checks the NAT type lets through, and report different ports to a symmetric client.
+// With `types.NATUDPBlock` no node answers HTTP/3, they are only reachable over TCP.
+func WithClientNAT(natType types.NATType) Option {
+	return func(opts *Options) {
+		opts.ClientNAT = natType
+	}
+}
+
+func defaultOptions() Options {
+	return Options{
+		Schedulers: 1,
+		Candidates: 3,
+		Edges:      []EdgeOptions{{}, {}, {}},
+		ClientNAT:  types.NATFullCone,
+	}
+}
+
+// Network is an in-process Titan network, every edge holds all files added to the network.
+type Network struct {
+	options    Options
+	ca         *authority
+	locator    *locator
+	schedulers []*Scheduler
+	candidates []*Candidate
+	edges      []*Edge
+
+	lk     sync.Mutex
+	blocks map[string][]byte // keyed by multihash, so both CIDv0 and CIDv1 are served
+	cars   map[string][]byte // keyed by the multihash of the root
+	tokens map[string]string // token id -> node id
+}
+
+// NewNetwork starts a fake network, the caller must close it.
+func NewNetwork(opts ...Option) (n *Network, err error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	n = &Network{
+		options: options,
+		blocks:  make(map[string][]byte),
+		cars:    make(map[string][]byte),
+		tokens:  make(map[string]string),
+	}
+
+	defer func() {
+		if err != nil {
+			n.Close()
+		}
+	}()
+
+	if n.ca, err = newAuthority(); err != nil {
+		return nil, err
+	}
+
+	if n.locator, err = newLocator(n); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < options.Schedulers; i++ {
+		scheduler, err := newScheduler(n)
+		if err != nil {
+			return nil, err
+		}
+		n.schedulers = append(n.schedulers, scheduler)
+	}
+
+	for i := 0; i < options.Candidates; i++ {
+		candidate, err := newCandidate(n, i)
+		if err != nil {
+			return nil, err
+		}
+		n.candidates = append(n.candidates, candidate)
+	}
+
+	for i, edgeOpts := range options.Edges {
+		edge, err := newEdge(n, fmt.Sprintf("e_%d", i), edgeOpts)
+		if err != nil {
+			return nil, err
+		}
+		n.edges = append(n.edges, edge)
+	}
+
+	return n, nil
+}
+
+// Address returns the address of the network to be used by `config.AddressOption`.
+func (n *Network) Address() string {
+	return fmt.Sprintf("https://%s", n.locator.server.addr())
+}
+
+// RootCAs returns the CA issuing the certificates of the nodes, to be used by `config.RootCAsOption`.
+func (n *Network) RootCAs() *x509.CertPool {
+	return n.ca.pool
+}
+
+// ClientOptions returns the options of a client of the network followed by the given ones, the client listens on
+// a random port of the loopback interface.
+func (n *Network) ClientOptions(opts ...config.Option) []config.Option {
+	return append([]config.Option{
+		config.AddressOption(n.Address()),
+		config.RootCAsOption(n.RootCAs()),
+		config.ListenAddressOption("127.0.0.1:0"),
+	}, opts...)
+}
+
+// Config returns the default configuration with the options of a client of the network applied, see ClientOptions.
+func (n *Network) Config(opts ...config.Option) config.Config {
+	options := config.DefaultOption()
+	for _, opt := range n.ClientOptions(opts...) {
+		opt(&options)
+	}
+	return options
+}
+
+// Scheduler returns the first scheduler of the network.
+func (n *Network) Scheduler() *Scheduler {
+	return n.schedulers[0]
+}
+
+// Schedulers returns the schedulers of the network.
+func (n *Network) Schedulers() []*Scheduler {
+	return n.schedulers
+}
+
+// Candidates returns the candidates of the network.
+func (n *Network) Candidates() []*Candidate {
+	return n.candidates
+}
+
+// Edges returns the edges of the network.
+func (n *Network) Edges() []*Edge {
+	return n.edges
+}
+
+// AddCAR adds the blocks of the CAR file to all edges and returns the root of it, the CAR file itself
+// is served to the range requests of the root.
+func (n *Network) AddCAR(data []byte) (cid.Cid, error) {
+	br, err := carv2.NewBlockReader(bytes.NewReader(data))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if len(br.Roots) == 0 {
+		return cid.Undef, errors.Errorf("car file has no root")
+	}
+
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	for {
+		block, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+
+		n.blocks[string(block.Cid().Hash())] = block.RawData()
+	}
+
+	root := br.Roots[0]
+	n.cars[string(root.Hash())] = data
+
+	return root, nil
+}
+
+// AddCARFile adds the CAR file at the path, see AddCAR.
+func (n *Network) AddCARFile(path string) (cid.Cid, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return n.AddCAR(data)
+}
+
+func (n *Network) has(c cid.Cid) bool {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	_, ok := n.blocks[string(c.Hash())]
+	return ok
+}
+
+func (n *Network) getBlock(c cid.Cid) ([]byte, bool) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	data, ok := n.blocks[string(c.Hash())]
+	return data, ok
+}
+
+// CAR returns the CAR file added to the network with the root.
+func (n *Network) CAR(c cid.Cid) ([]byte, bool) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	data, ok := n.cars[string(c.Hash())]
+	return data, ok
+}
+
+// issueToken issues a download token of the edge.
+func (n *Network) issueToken(nodeID string) *types.Token {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	id := uuid.NewString()
+	n.tokens[id] = nodeID
+
+	return &types.Token{ID: id}
+}
+
+// tokenOwner returns the node id the token was issued for.
+func (n *Network) tokenOwner(id string) (string, bool) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	nodeID, ok := n.tokens[id]
+	return nodeID, ok
+}
+
+// schedulerOf returns the scheduler of the i-th edge.
+func (n *Network) schedulerOf(i int) *Scheduler {
+	return n.schedulers[i%len(n.schedulers)]
+}
+
+func (n *Network) edge(nodeID string) *Edge {
+	for _, edge := range n.edges {
+		if edge.NodeID == nodeID {
+			return edge
+		}
+	}
+	return nil
+}
+
+// Close stops all nodes of the network.
+func (n *Network) Close() error {
+	for _, edge := range n.edges {
+		edge.server.close()
+	}
+
+	for _, candidate := range n.candidates {
+		candidate.close()
+	}
+
+	for _, scheduler := range n.schedulers {
+		scheduler.server.close()
+	}
+
+	if n.locator != nil {
+		n.locator.server.close()
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package titantest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/gnasnik/titan-sdk-go/internal/codec"
+	"github.com/gnasnik/titan-sdk-go/internal/crypto"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	schedulerVersion = "titantest-scheduler"
+
+	pushPath = "/rpc/streams/v0/push/"
+	// pushWaitTimeout is how long the submission of a workload report waits for the pushed data
+	pushWaitTimeout = 10 * time.Second
+)
+
+// readerStream mirrors `titan.ReaderStream`, the params of `titan.SubmitUserWorkloadReport`.
+type readerStream struct {
+	Type string
+	Info string
+}
+
+// Scheduler is a fake scheduler of the network, the edges are spread over the schedulers.
+type Scheduler struct {
+	network   *Network
+	server    *server
+	key       *rsa.PrivateKey
+	publicKey string
+
+	lk      sync.Mutex
+	cond    *sync.Cond
+	pushes  map[string][]byte
+	reports []*types.WorkloadReport
+	punches []string
+	// failures is the number of the next workload report submissions to fail
+	failures    int
+	unavailable bool
+}
+
+func newScheduler(n *Network) (*Scheduler, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{
+		network: n,
+		key:     key,
+		publicKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey),
+		})),
+		pushes: make(map[string][]byte),
+	}
+	s.cond = sync.NewCond(&s.lk)
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", rpcServer{
+		"titan.Version": func(r *http.Request, params []json.RawMessage) (interface{}, error) {
+			return schedulerVersion, nil
+		},
+		"titan.GetCandidateURLsForDetectNat": s.getCandidateURLs,
+		"titan.NatPunch":                     s.natPunch,
+		"titan.SubmitUserWorkloadReport":     s.submitUserWorkloadReport,
+		"titan.CreateAsset":                  s.createAsset,
+		"titan.GetNodePublicKey":             s.getNodePublicKey,
+	})
+	mux.HandleFunc(pushPath, s.push)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isUnavailable() {
+			http.Error(w, "scheduler unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	if s.server, err = n.newServer(handler, nil); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reports returns the workload reports submitted to the scheduler.
+func (s *Scheduler) Reports() []*types.WorkloadReport {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return append([]*types.WorkloadReport(nil), s.reports...)
+}
+
+// FailReports makes the next n submissions of workload reports fail, the pushed data is discarded.
+func (s *Scheduler) FailReports(n int) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.failures = n
+}
+
+// Punches returns the node ids of the edges the clients asked to punch.
+func (s *Scheduler) Punches() []string {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return append([]string(nil), s.punches...)
+}
+
+// SetUnavailable makes the scheduler answer every request with an error, as if it was down.
+func (s *Scheduler) SetUnavailable(unavailable bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.unavailable = unavailable
+}
+
+func (s *Scheduler) isUnavailable() bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.unavailable
+}
+
+// URL returns the JSON-RPC url of the scheduler, as listed in the access point.
+func (s *Scheduler) URL() string {
+	return s.server.rpcURL()
+}
+
+// edgeDownloadInfos returns the edges of the scheduler, nil if none, every edge holds all files of the network.
+func (s *Scheduler) edgeDownloadInfos() *types.EdgeDownloadInfoList {
+	list := &types.EdgeDownloadInfoList{
+		SchedulerURL: s.server.rpcURL(),
+		SchedulerKey: s.publicKey,
+	}
+
+	for i, edge := range s.network.edges {
+		if s.network.schedulerOf(i) != s {
+			continue
+		}
+
+		list.Infos = append(list.Infos, &types.EdgeDownloadInfo{
+			Address: edge.advertisedAddress(),
+			Tk:      s.network.issueToken(edge.NodeID),
+			NodeID:  edge.NodeID,
+			NatType: edge.Options.NATType,
+		})
+	}
+
+	if len(list.Infos) == 0 {
+		return nil
+	}
+
+	return list
+}
+
+func (s *Scheduler) getCandidateURLs(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var urls []string
+	for _, candidate := range s.network.candidates {
+		urls = append(urls, candidate.server.rpcURL())
+	}
+	return urls, nil
+}
+
+func (s *Scheduler) natPunch(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var req types.NatPunchReq
+	if len(params) == 0 || json.Unmarshal(params[0], &req) != nil {
+		return nil, errors.Errorf("invalid params")
+	}
+
+	if s.network.edge(req.NodeID) == nil {
+		return nil, errors.Errorf("node %s not found", req.NodeID)
+	}
+
+	s.lk.Lock()
+	s.punches = append(s.punches, req.NodeID)
+	s.lk.Unlock()
+
+	return nil, nil
+}
+
+// getNodePublicKey answers the public key of the edge, the key its certificate must hold.
+func (s *Scheduler) getNodePublicKey(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var nodeID string
+	if len(params) == 0 || json.Unmarshal(params[0], &nodeID) != nil {
+		return nil, errors.Errorf("invalid params")
+	}
+
+	edge := s.network.edge(nodeID)
+	if edge == nil {
+		return nil, errors.Errorf("node %s not found", nodeID)
+	}
+
+	return encodePublicKey(edge.key.Public())
+}
+
+func (s *Scheduler) submitUserWorkloadReport(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var stream readerStream
+	if len(params) == 0 || json.Unmarshal(params[0], &stream) != nil {
+		return nil, errors.Errorf("invalid params")
+	}
+
+	data, err := s.waitPush(stream.Info)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lk.Lock()
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	s.lk.Unlock()
+
+	if fail {
+		return nil, errors.Errorf("workload report rejected")
+	}
+
+	plaintext, err := crypto.Decrypt(data, s.key)
+	if err != nil {
+		return nil, errors.Errorf("decrypt workload report: %v", err)
+	}
+
+	var reports []*types.WorkloadReport
+	if err = codec.Decode(plaintext, &reports); err != nil {
+		return nil, errors.Errorf("decode workload report: %v", err)
+	}
+
+	s.lk.Lock()
+	s.reports = append(s.reports, reports...)
+	s.lk.Unlock()
+
+	return nil, nil
+}
+
+// createAsset lets the client upload the asset to the candidates, unless the network already has it.
+func (s *Scheduler) createAsset(r *http.Request, params []json.RawMessage) (interface{}, error) {
+	var asset types.AssetProperty
+	if len(params) == 0 || json.Unmarshal(params[0], &asset) != nil {
+		return nil, errors.Errorf("invalid params")
+	}
+
+	root, err := cid.Decode(asset.AssetCID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.network.has(root) {
+		return &types.UploadInfo{AlreadyExists: true}, nil
+	}
+
+	info := &types.UploadInfo{}
+	for _, candidate := range s.network.candidates {
+		info.List = append(info.List, &types.NodeUploadInfo{
+			UploadURL: candidate.uploadURL(),
+			Token:     s.network.issueToken(candidate.NodeID).ID,
+			NodeID:    candidate.NodeID,
+		})
+	}
+
+	return info, nil
+}
+
+// push receives the data of a reader param, the client checks the url with a HEAD request before posting the data.
+func (s *Scheduler) push(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, pushPath)
+
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPost:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.lk.Lock()
+		s.pushes[id] = data
+		s.cond.Broadcast()
+		s.lk.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// waitPush waits for the data pushed with the id, the push and the rpc call are sent concurrently by the client.
+func (s *Scheduler) waitPush(id string) ([]byte, error) {
+	timer := time.AfterFunc(pushWaitTimeout, func() {
+		s.lk.Lock()
+		s.cond.Broadcast()
+		s.lk.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(pushWaitTimeout)
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	for {
+		if data, ok := s.pushes[id]; ok {
+			delete(s.pushes, id)
+			return data, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("push %s not received", id)
+		}
+
+		s.cond.Wait()
+	}
+}
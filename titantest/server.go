@@ -0,0 +1,252 @@
+package titantest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/gnasnik/titan-sdk-go/internal/request"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/quic-go/quic-go/http3"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// server serves HTTP/3 and HTTPS over TCP on the same random port of the loopback interface, like the Titan nodes.
+type server struct {
+	conn net.PacketConn
+	ln   net.Listener
+	srv  *http3.Server
+	tcp  *http.Server
+}
+
+// newServer starts a server presenting a certificate of the key, a key is generated if key is nil. The server does
+// not answer HTTP/3 if the UDP of the client is blocked.
+func (n *Network) newServer(handler http.Handler, key crypto.Signer) (*server, error) {
+	cert, err := n.ca.issue(key)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, ln, err := listen()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	s := &server{
+		conn: conn,
+		ln:   ln,
+		srv: &http3.Server{
+			TLSConfig: tlsConf,
+			Handler:   handler,
+		},
+		tcp: &http.Server{
+			TLSConfig:         tlsConf.Clone(),
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+			// the handshakes aborted by closing clients are not worth logging
+			ErrorLog: stdlog.New(io.Discard, "", 0),
+		},
+	}
+
+	// the packets are still received, but never answered
+	if n.options.ClientNAT != types.NATUDPBlock {
+		go s.srv.Serve(conn)
+	}
+	go s.tcp.ServeTLS(ln, "", "")
+
+	return s, nil
+}
+
+// listen listens on the same random port for udp and tcp.
+func listen() (net.PacketConn, net.Listener, error) {
+	for i := 0; ; i++ {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ln, err := net.Listen("tcp4", conn.LocalAddr().String())
+		if err == nil {
+			return conn, ln, nil
+		}
+		conn.Close()
+
+		// the tcp port is taken, try another one
+		if i == 10 {
+			return nil, nil, err
+		}
+	}
+}
+
+// addr returns the `ip:port` the server listens on.
+func (s *server) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// rpcURL returns the JSON-RPC url of the server.
+func (s *server) rpcURL() string {
+	return fmt.Sprintf("https://%s/rpc/v0", s.addr())
+}
+
+func (s *server) close() error {
+	s.srv.Close()
+	s.tcp.Close()
+	return s.conn.Close()
+}
+
+// authority is the CA of the network, it issues the certificates of all nodes for the loopback interface.
+type authority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pool *x509.CertPool
+
+	lk     sync.Mutex
+	serial int64
+}
+
+func newAuthority() (*authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "titantest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &authority{cert: cert, key: key, pool: pool, serial: 1}, nil
+}
+
+// issue issues a certificate of the key valid for 127.0.0.1 and localhost, a new key is generated if key is nil.
+func (a *authority) issue(key crypto.Signer) (tls.Certificate, error) {
+	if key == nil {
+		var err error
+		if key, err = generateKey(); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	a.lk.Lock()
+	a.serial++
+	serial := a.serial
+	a.lk.Unlock()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "titantest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, key.Public(), a.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func generateKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// encodePublicKey encodes the public key to PEM, like the schedulers advertise the keys of the nodes.
+func encodePublicKey(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// rpcHandler is the handler of a JSON-RPC method, params is the raw params array of the request.
+type rpcHandler func(r *http.Request, params []json.RawMessage) (interface{}, error)
+
+// rpcServer dispatches JSON-RPC requests by method name.
+type rpcServer map[string]rpcHandler
+
+func (rs rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+	}
+
+	handler, ok := rs[req.Method]
+	if !ok {
+		resp["error"] = map[string]interface{}{"code": -32601, "message": fmt.Sprintf("method %s not found", req.Method)}
+		writeJSON(w, resp)
+		return
+	}
+
+	var params []json.RawMessage
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp["error"] = map[string]interface{}{"code": -32602, "message": err.Error()}
+			writeJSON(w, resp)
+			return
+		}
+	}
+
+	result, err := handler(r, params)
+	if err != nil {
+		resp["error"] = map[string]interface{}{"code": 1, "message": err.Error()}
+	} else {
+		resp["result"] = result
+	}
+
+	writeJSON(w, resp)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("write response failed: %v", err)
+	}
+}
@@ -125,6 +125,12 @@ type Workload struct {
 	DownloadSize  int64
 	StartTime     int64
 	EndTime       int64
+	// RequestCount is the number of requests served, the speeds are percentiles of the speeds of the requests in
+	// bytes per second. The schedulers decoding an older Workload ignore them.
+	RequestCount int64
+	SpeedP50     int64
+	SpeedP90     int64
+	SpeedP99     int64
 }
 
 type WorkloadReport struct {
@@ -133,3 +139,23 @@ type WorkloadReport struct {
 	NodeID   string
 	Workload *Workload
 }
+
+// AssetProperty describes an asset to be uploaded
+type AssetProperty struct {
+	AssetCID  string
+	AssetName string
+	AssetSize int64
+}
+
+// NodeUploadInfo is a candidate accepting the upload of an asset, the token authorizes the upload
+type NodeUploadInfo struct {
+	UploadURL string
+	Token     string
+	NodeID    string
+}
+
+// UploadInfo lists the candidates to upload an asset to, AlreadyExists reports the asset is already stored in the network
+type UploadInfo struct {
+	List          []*NodeUploadInfo
+	AlreadyExists bool
+}
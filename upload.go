@@ -0,0 +1,184 @@
+package titan
+
+import (
+	"context"
+	"github.com/gnasnik/titan-sdk-go/cache"
+	"github.com/gnasnik/titan-sdk-go/merkledag"
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	carv2 "github.com/ipld/go-car/v2"
+	carbs "github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/pkg/errors"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+func (c *Client) PutFile(ctx context.Context, path string) (cid.Cid, error) {
+	tmpDir, err := os.MkdirTemp("", "titan-upload-")
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	carPath := filepath.Join(tmpDir, filepath.Base(path)+".car")
+	if _, err = createCAR(ctx, path, tmpDir, carPath); err != nil {
+		return cid.Undef, err
+	}
+
+	return c.PutCAR(ctx, carPath)
+}
+
+func (c *Client) PutCAR(ctx context.Context, path string) (cid.Cid, error) {
+	root, size, err := carRoot(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	info, err := c.titan.CreateAsset(ctx, &types.AssetProperty{
+		AssetCID:  root.String(),
+		AssetName: filepath.Base(path),
+		AssetSize: size,
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if info.AlreadyExists {
+		log.Debugf("asset %s already exists", root)
+		return root, nil
+	}
+
+	if len(info.List) == 0 {
+		return cid.Undef, errors.Errorf("no candidate to upload")
+	}
+
+	for _, node := range info.List {
+		if err = ctx.Err(); err != nil {
+			return cid.Undef, err
+		}
+
+		if err = c.uploadCAR(ctx, node, path); err != nil {
+			log.Warnf("upload %s failed: %v", root, err)
+			continue
+		}
+
+		return root, nil
+	}
+
+	return cid.Undef, errors.Errorf("upload %s failed: %v", root, err)
+}
+
+func (c *Client) uploadCAR(ctx context.Context, node *types.NodeUploadInfo, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.titan.UploadCAR(ctx, node, filepath.Base(path), f)
+}
+
+// carRoot returns the root and the size of the CAR file.
+func carRoot(path string) (cid.Cid, int64, error) {
+	r, err := carv2.OpenReader(path)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	defer r.Close()
+
+	roots, err := r.Roots()
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	if len(roots) != 1 {
+		return cid.Undef, 0, errors.Errorf("car file must have exactly one root, got %d", len(roots))
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	return roots[0], stat.Size(), nil
+}
+
+// createCAR chunks the file into a UnixFS DAG with raw leaves and CIDv1, then writes the DAG to a CARv1 file in DFS
+// pre-order, which is the order the edges serve it. The blocks are staged in the directory while the DAG is built.
+func createCAR(ctx context.Context, path, stagingDir, carPath string) (cid.Cid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer f.Close()
+
+	staging, err := cache.NewDiskBlockstore(filepath.Join(stagingDir, "blocks"), math.MaxInt64)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	dag := merkledag.NewStagingDAGService(nil, staging)
+
+	params := helpers.DagBuilderParams{
+		Maxlinks:   helpers.DefaultLinksPerBlock,
+		RawLeaves:  true,
+		CidBuilder: cid.V1Builder{Codec: cid.DagProtobuf, MhType: multihash.SHA2_256},
+		Dagserv:    dag,
+	}
+
+	db, err := params.New(chunker.NewSizeSplitter(f, chunker.DefaultBlockSize))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	root, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	bs, err := carbs.OpenReadWrite(carPath, []cid.Cid{root.Cid()}, carbs.WriteAsCarV1(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err = writeDAG(ctx, dag, bs, root.Cid(), cid.NewSet()); err != nil {
+		bs.Discard()
+		return cid.Undef, err
+	}
+
+	if err = bs.Finalize(); err != nil {
+		return cid.Undef, err
+	}
+
+	return root.Cid(), nil
+}
+
+// writeDAG writes the blocks of the DAG in DFS pre-order, a block linked more than once is only written the first time.
+func writeDAG(ctx context.Context, dag ipld.DAGService, bs *carbs.ReadWrite, c cid.Cid, written *cid.Set) error {
+	if !written.Visit(c) {
+		return nil
+	}
+
+	node, err := dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err = bs.Put(ctx, node); err != nil {
+		return err
+	}
+
+	for _, link := range node.Links() {
+		if err = writeDAG(ctx, dag, bs, link.Cid, written); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
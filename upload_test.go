@@ -0,0 +1,117 @@
+package titan
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutFile(t *testing.T) {
+	network := newTestNetwork(t)
+	client := newTestClient(t, network)
+
+	// larger than a chunk of the importer, so the file has a root linking the chunks
+	data := make([]byte, 600<<10)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	root, err := client.PutFile(testContext(t), path)
+	if err != nil {
+		t.Fatalf("put file: %v", err)
+	}
+
+	_, reader, err := client.GetFile(testContext(t), root.String())
+	if err != nil {
+		t.Fatalf("get file: %v", err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes not matching the %d bytes uploaded", len(got), len(data))
+	}
+
+	// the network already has the file
+	again, err := client.PutFile(testContext(t), path)
+	if err != nil {
+		t.Fatalf("put file again: %v", err)
+	}
+	if !again.Equals(root) {
+		t.Errorf("root of the file put again = %s, want %s", again, root)
+	}
+}
+
+func TestPutCAR(t *testing.T) {
+	// the CAR file is packed by another network, like a CAR exported from IPFS
+	source := newTestNetwork(t)
+	want, data := addRandomFile(t, source, 100<<10)
+	car, _ := source.CAR(want)
+
+	dir := t.TempDir()
+	carPath := filepath.Join(dir, "file.car")
+	if err := os.WriteFile(carPath, car, 0644); err != nil {
+		t.Fatalf("write car: %v", err)
+	}
+	invalidPath := filepath.Join(dir, "invalid.car")
+	if err := os.WriteFile(invalidPath, []byte("not a car file"), 0644); err != nil {
+		t.Fatalf("write invalid car: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"car file", carPath, false},
+		{"invalid car file", invalidPath, true},
+		{"missing car file", filepath.Join(dir, "missing.car"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := newTestNetwork(t)
+			client := newTestClient(t, network)
+
+			root, err := client.PutCAR(testContext(t), tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("put car: err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !root.Equals(want) {
+				t.Errorf("root = %s, want %s", root, want)
+			}
+
+			if uploaded, _ := network.CAR(root); !bytes.Equal(uploaded, car) {
+				t.Errorf("car file received by the network does not match")
+			}
+
+			_, reader, err := client.GetFile(testContext(t), root.String())
+			if err != nil {
+				t.Fatalf("get file: %v", err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("read file: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("got %d bytes not matching the %d bytes uploaded", len(got), len(data))
+			}
+		})
+	}
+}
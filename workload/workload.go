@@ -0,0 +1,268 @@
+// Package workload accounts the data served by the edges, every transfer is recorded and aggregated per token,
+// the aggregates are the workload reported to the schedulers, which rewards the edges for what they served.
+package workload
+
+import (
+	"github.com/gnasnik/titan-sdk-go/types"
+	"github.com/ipfs/go-cid"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// bucketsPerDoubling is the resolution of the speed histogram, the relative error of a percentile is below 9%
+	bucketsPerDoubling = 4
+	// maxBucket bounds the histogram to 2^40 bytes per second
+	maxBucket = 40 * bucketsPerDoubling
+)
+
+// Transfer is a single request served by an edge.
+type Transfer struct {
+	NodeID  string
+	TokenID string
+	Cid     cid.Cid
+	Start   time.Time
+	// Duration is the time from sending the request to receiving the whole response
+	Duration time.Duration
+	Bytes    int64
+	// RangeStart and RangeEnd are the inclusive bounds of a range request, both zero for a block
+	RangeStart int64
+	RangeEnd   int64
+}
+
+// Speed returns the download speed of the transfer in bytes per second.
+func (t Transfer) Speed() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Duration.Seconds()
+}
+
+// Interval is a period of time spent transferring.
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Stats aggregates the transfers of a token, it is mergeable, so the workload of an interrupted download can be
+// restored and accumulated by the download resuming it.
+type Stats struct {
+	TokenID  string
+	NodeID   string
+	Requests int64
+	Bytes    int64
+	// Duration is the wall-clock time spent transferring, from the first byte to the last, the concurrent transfers
+	// are counted once and the gaps between transfers which do not overlap are left out, e.g. the pause of
+	// an interrupted download
+	Duration time.Duration
+	Start    time.Time
+	End      time.Time
+	// Intervals are the periods spent transferring, sorted and coalesced, Duration is their total
+	Intervals []Interval
+	// Histogram counts the transfers by speed, the bucket i holds speeds in [2^(i/4), 2^((i+1)/4)) bytes per second
+	Histogram map[int]int64
+}
+
+// NewStats creates empty stats of the token served by the node.
+func NewStats(tokenID, nodeID string) *Stats {
+	return &Stats{
+		TokenID:   tokenID,
+		NodeID:    nodeID,
+		Histogram: make(map[int]int64),
+	}
+}
+
+// Add records the transfer.
+func (s *Stats) Add(t Transfer) {
+	s.Requests++
+	s.Bytes += t.Bytes
+	s.span(t.Start, t.Start.Add(t.Duration))
+	s.Histogram[bucket(t.Speed())]++
+}
+
+// Merge accumulates the other stats of the same token.
+func (s *Stats) Merge(other *Stats) {
+	s.Requests += other.Requests
+	s.Bytes += other.Bytes
+	for _, interval := range other.Intervals {
+		s.span(interval.Start, interval.End)
+	}
+	s.extend(other.Start, other.End)
+
+	for b, count := range other.Histogram {
+		s.Histogram[b] += count
+	}
+}
+
+// span accounts the time from start to end, the time already covered by the stats is not counted twice.
+func (s *Stats) span(start, end time.Time) {
+	s.extend(start, end)
+
+	if !end.After(start) {
+		return
+	}
+
+	// the intervals from i to j overlap or touch the new one, they are coalesced into it
+	i := sort.Search(len(s.Intervals), func(i int) bool {
+		return !s.Intervals[i].End.Before(start)
+	})
+	j := sort.Search(len(s.Intervals), func(j int) bool {
+		return s.Intervals[j].Start.After(end)
+	})
+
+	merged := Interval{Start: start, End: end}
+	if i < j {
+		if s.Intervals[i].Start.Before(start) {
+			merged.Start = s.Intervals[i].Start
+		}
+		if s.Intervals[j-1].End.After(end) {
+			merged.End = s.Intervals[j-1].End
+		}
+	}
+
+	intervals := make([]Interval, 0, len(s.Intervals)-(j-i)+1)
+	intervals = append(intervals, s.Intervals[:i]...)
+	intervals = append(intervals, merged)
+	s.Intervals = append(intervals, s.Intervals[j:]...)
+
+	s.Duration = 0
+	for _, interval := range s.Intervals {
+		s.Duration += interval.End.Sub(interval.Start)
+	}
+}
+
+func (s *Stats) extend(start, end time.Time) {
+	if start.IsZero() {
+		return
+	}
+
+	if s.Start.IsZero() || start.Before(s.Start) {
+		s.Start = start
+	}
+
+	if end.After(s.End) {
+		s.End = end
+	}
+}
+
+// Speed returns the time-weighted mean speed of the transfers in bytes per second, that is the bytes transferred
+// over the wall-clock time spent transferring them, so a slow large transfer weighs more than a fast small one.
+func (s *Stats) Speed() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// Percentile returns the speed in bytes per second which p percent of the transfers are slower than, p is in [0, 100].
+func (s *Stats) Percentile(p float64) float64 {
+	var total int64
+	buckets := make([]int, 0, len(s.Histogram))
+	for b, count := range s.Histogram {
+		buckets = append(buckets, b)
+		total += count
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	sort.Ints(buckets)
+
+	// nearest rank
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for _, b := range buckets {
+		seen += s.Histogram[b]
+		if seen >= rank {
+			return bucketSpeed(b)
+		}
+	}
+
+	return bucketSpeed(buckets[len(buckets)-1])
+}
+
+// Workload returns the workload reported to the scheduler.
+func (s *Stats) Workload() *types.Workload {
+	workload := &types.Workload{
+		DownloadSpeed: int64(s.Speed()),
+		DownloadSize:  s.Bytes,
+		RequestCount:  s.Requests,
+		SpeedP50:      int64(s.Percentile(50)),
+		SpeedP90:      int64(s.Percentile(90)),
+		SpeedP99:      int64(s.Percentile(99)),
+	}
+
+	if !s.Start.IsZero() {
+		workload.StartTime = s.Start.Unix()
+		workload.EndTime = s.End.Unix()
+	}
+
+	return workload
+}
+
+// Clone returns a deep copy of the stats.
+func (s *Stats) Clone() *Stats {
+	clone := *s
+	clone.Intervals = append([]Interval(nil), s.Intervals...)
+	clone.Histogram = make(map[int]int64, len(s.Histogram))
+	for b, count := range s.Histogram {
+		clone.Histogram[b] = count
+	}
+	return &clone
+}
+
+// FromWorkload converts a reported workload back to stats, the transfers are unknown so the workload is taken
+// as a single transfer.
+func FromWorkload(report *types.WorkloadReport) *Stats {
+	s := NewStats(report.TokenID, report.NodeID)
+
+	w := report.Workload
+	if w == nil || w.DownloadSize == 0 {
+		return s
+	}
+
+	t := Transfer{
+		NodeID:  report.NodeID,
+		TokenID: report.TokenID,
+		Start:   time.Unix(w.StartTime, 0),
+		Bytes:   w.DownloadSize,
+	}
+
+	if w.DownloadSpeed > 0 {
+		t.Duration = time.Duration(float64(w.DownloadSize) / float64(w.DownloadSpeed) * float64(time.Second))
+	} else {
+		t.Duration = time.Duration(w.EndTime-w.StartTime) * time.Second
+	}
+
+	s.Add(t)
+
+	if w.RequestCount > 0 {
+		s.Requests = w.RequestCount
+	}
+
+	return s
+}
+
+func bucket(speed float64) int {
+	if speed < 1 {
+		return 0
+	}
+
+	b := int(math.Floor(math.Log2(speed) * bucketsPerDoubling))
+	if b > maxBucket {
+		b = maxBucket
+	}
+
+	return b
+}
+
+// bucketSpeed returns the geometric middle of the bucket.
+func bucketSpeed(b int) float64 {
+	return math.Exp2((float64(b) + 0.5) / bucketsPerDoubling)
+}
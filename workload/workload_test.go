@@ -0,0 +1,116 @@
+package workload
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+// transfer returns a transfer from the second start to the second end after the epoch.
+func transfer(start, end int) Transfer {
+	return Transfer{
+		Start:    epoch.Add(time.Duration(start) * time.Second),
+		Duration: time.Duration(end-start) * time.Second,
+		Bytes:    1 << 20,
+	}
+}
+
+func TestStatsDuration(t *testing.T) {
+	tests := []struct {
+		name      string
+		transfers []Transfer
+		want      time.Duration
+		// wantIntervals is the number of intervals left once coalesced
+		wantIntervals int
+	}{
+		{"single", []Transfer{transfer(0, 10)}, 10 * time.Second, 1},
+		{"overlapping", []Transfer{transfer(0, 10), transfer(5, 15)}, 15 * time.Second, 1},
+		{"nested", []Transfer{transfer(0, 30), transfer(10, 20)}, 30 * time.Second, 1},
+		{"touching", []Transfer{transfer(0, 10), transfer(10, 20)}, 20 * time.Second, 1},
+		{"disjoint", []Transfer{transfer(0, 10), transfer(20, 30)}, 20 * time.Second, 2},
+		{"disjoint out of order", []Transfer{transfer(20, 30), transfer(0, 10)}, 20 * time.Second, 2},
+		{"filling a gap", []Transfer{transfer(0, 10), transfer(20, 30), transfer(5, 25)}, 30 * time.Second, 1},
+		{"filling a gap partly", []Transfer{transfer(0, 10), transfer(20, 30), transfer(12, 25)}, 28 * time.Second, 2},
+		{"spanning several", []Transfer{transfer(10, 20), transfer(30, 40), transfer(50, 60), transfer(0, 70)}, 70 * time.Second, 1},
+		{"zero length", []Transfer{transfer(0, 10), transfer(20, 20)}, 10 * time.Second, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStats("token", "node")
+			for _, transfer := range tt.transfers {
+				s.Add(transfer)
+			}
+
+			if s.Duration != tt.want {
+				t.Errorf("duration = %s, want %s", s.Duration, tt.want)
+			}
+			if len(s.Intervals) != tt.wantIntervals {
+				t.Errorf("intervals = %v, want %d", s.Intervals, tt.wantIntervals)
+			}
+			if s.Requests != int64(len(tt.transfers)) {
+				t.Errorf("requests = %d, want %d", s.Requests, len(tt.transfers))
+			}
+		})
+	}
+}
+
+func TestStatsMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  []Transfer
+		want  time.Duration
+		start int
+		end   int
+	}{
+		{"overlapping", []Transfer{transfer(0, 10)}, []Transfer{transfer(5, 15)}, 15 * time.Second, 0, 15},
+		{"disjoint", []Transfer{transfer(0, 10)}, []Transfer{transfer(20, 30)}, 20 * time.Second, 0, 30},
+		{"filling a gap", []Transfer{transfer(0, 10), transfer(20, 30)}, []Transfer{transfer(5, 25)}, 30 * time.Second, 0, 30},
+		{"gaps of both", []Transfer{transfer(0, 10), transfer(40, 50)}, []Transfer{transfer(20, 30), transfer(45, 60)}, 40 * time.Second, 0, 60},
+		{"into empty", nil, []Transfer{transfer(0, 10), transfer(20, 30)}, 20 * time.Second, 0, 30},
+		{"empty", []Transfer{transfer(0, 10)}, nil, 10 * time.Second, 0, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := NewStats("token", "node"), NewStats("token", "node")
+			for _, transfer := range tt.a {
+				a.Add(transfer)
+			}
+			for _, transfer := range tt.b {
+				b.Add(transfer)
+			}
+
+			merged := a.Clone()
+			merged.Merge(b)
+
+			if merged.Duration != tt.want {
+				t.Errorf("duration = %s, want %s", merged.Duration, tt.want)
+			}
+			if want := epoch.Add(time.Duration(tt.start) * time.Second); !merged.Start.Equal(want) {
+				t.Errorf("start = %s, want %s", merged.Start, want)
+			}
+			if want := epoch.Add(time.Duration(tt.end) * time.Second); !merged.End.Equal(want) {
+				t.Errorf("end = %s, want %s", merged.End, want)
+			}
+			if merged.Requests != int64(len(tt.a)+len(tt.b)) {
+				t.Errorf("requests = %d, want %d", merged.Requests, len(tt.a)+len(tt.b))
+			}
+
+			// the merged stats do not share the intervals of the stats they were cloned from
+			if a.Duration != durationOf(tt.a) {
+				t.Errorf("duration of the clone source = %s, want %s", a.Duration, durationOf(tt.a))
+			}
+		})
+	}
+}
+
+// durationOf returns the duration of the transfers, the transfers must not overlap.
+func durationOf(transfers []Transfer) time.Duration {
+	var d time.Duration
+	for _, t := range transfers {
+		d += t.Duration
+	}
+	return d
+}